@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+)
+
+// utf8BOM is the UTF-8 encoded byte order mark written by -emit-bom.
+const utf8BOM = "\xef\xbb\xbf"
+
+// batchWriter writes output to numbered files, starting a new file after
+// every batchLines records. Files are named by formatting pattern with a
+// 1-based batch number and are created when their first byte is written.
+type batchWriter struct {
+	pattern    string
+	batchLines int
+	emitBOM    bool
+	records    int
+	batch      int
+	file       *os.File
+	writer     *bufio.Writer
+}
+
+func newBatchWriter(pattern string, batchLines int, emitBOM bool) *batchWriter {
+	return &batchWriter{pattern: pattern, batchLines: batchLines, emitBOM: emitBOM}
+}
+
+func (b *batchWriter) Write(p []byte) (int, error) {
+	if b.file == nil {
+		b.batch++
+		name := fmt.Sprintf(b.pattern, b.batch)
+		f, err := os.Create(name)
+		if err != nil {
+			return 0, fmt.Errorf("batch file create error: %w", err)
+		}
+		b.file = f
+		b.writer = bufio.NewWriterSize(f, 4*1024*1024)
+		if b.emitBOM {
+			_, _ = b.writer.WriteString(utf8BOM)
+		}
+	}
+	return b.writer.Write(p)
+}
+
+// EndRecord marks the end of a record and closes the current file once it
+// holds batchLines records.
+func (b *batchWriter) EndRecord() error {
+	b.records++
+	if b.records < b.batchLines {
+		return nil
+	}
+	b.records = 0
+	return b.Close()
+}
+
+// Flush writes buffered data of the current batch file to disk.
+func (b *batchWriter) Flush() error {
+	if b.writer == nil {
+		return nil
+	}
+	return b.writer.Flush()
+}
+
+func (b *batchWriter) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	err := b.writer.Flush()
+	if closeErr := b.file.Close(); err == nil {
+		err = closeErr
+	}
+	b.file = nil
+	b.writer = nil
+	return err
+}
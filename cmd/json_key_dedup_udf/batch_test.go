@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunBatchesOutputIntoFiles(t *testing.T) {
+	dir := t.TempDir()
+	pattern := filepath.Join(dir, "out-%d.ndjson")
+	out := newBatchWriter(pattern, 3, false)
+
+	input := "{\"n\":1,\"n\":0}\n{\"n\":2}\n{\"n\":3}\n{\"n\":4}\n{\"n\":5}\n"
+	if err := run(strings.NewReader(input), out, &options{}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	want := map[string]string{
+		"out-1.ndjson": "{\"n\":1}\n{\"n\":2}\n{\"n\":3}\n",
+		"out-2.ndjson": "{\"n\":4}\n{\"n\":5}\n",
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d batch files, want %d", len(entries), len(want))
+	}
+	for name, content := range want {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		if string(data) != content {
+			t.Fatalf("%s = %q, want %q", name, data, content)
+		}
+	}
+}
+
+func TestBatchWriterEmitsBOMPerFile(t *testing.T) {
+	dir := t.TempDir()
+	out := newBatchWriter(filepath.Join(dir, "out-%d.ndjson"), 1, true)
+
+	if err := run(strings.NewReader("{\"a\":1}\n{\"b\":2}\n"), out, &options{emitBOM: true}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"out-1.ndjson": utf8BOM + "{\"a\":1}\n",
+		"out-2.ndjson": utf8BOM + "{\"b\":2}\n",
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		if string(data) != want {
+			t.Fatalf("%s = %q, want %q", name, data, want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/valyala/fastjson"
+)
+
+// loadObjectFile parses a JSON file that must hold a single object and
+// returns it deduplicated with the default rule. Integers beyond maxSafeInt
+// become strings, as in records.
+func loadObjectFile(path, maxSafeInt string) (*objectNode, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := parserPool.Get().(*fastjson.Parser)
+	defer parserPool.Put(parser)
+	value, err := parser.ParseBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: json parse error: %w", path, err)
+	}
+	parsed, err := convertFastJSON(value, 0, maxSafeInt)
+	if err != nil {
+		return nil, fmt.Errorf("%s: json parse error: %w", path, err)
+	}
+	deduped, err := parsed.Dedup(&dedupContext{opts: &options{}})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	obj, ok := deduped.(*objectNode)
+	if !ok {
+		return nil, fmt.Errorf("%s: expected a JSON object", path)
+	}
+	return obj, nil
+}
+
+// applyDefaults appends a copy of every defaults entry whose key is absent
+// from obj.
+func applyDefaults(obj *objectNode, defaults *objectNode) {
+	for _, def := range defaults.entries {
+		if !hasKey(obj, def.key) {
+			obj.entries = append(obj.entries, objectEntry{key: def.key, value: cloneNode(def.value)})
+		}
+	}
+}
+
+// applyEnrich merges a copy of every enrich entry into obj. Keys obj lacks
+// are appended; keys it has are replaced only when overwrite is set. The
+// replaced value is not recycled because obj may be a -select tree that
+// shares it with the record.
+func applyEnrich(obj *objectNode, enrich *objectNode, overwrite bool) {
+	for _, add := range enrich.entries {
+		idx := -1
+		for i, entry := range obj.entries {
+			if entry.key == add.key {
+				idx = i
+				break
+			}
+		}
+		switch {
+		case idx < 0:
+			obj.entries = append(obj.entries, objectEntry{key: add.key, value: cloneNode(add.value)})
+		case overwrite:
+			obj.entries[idx].value = cloneNode(add.value)
+		}
+	}
+}
+
+// applyNullMissing appends an explicit null for every listed key absent
+// from obj.
+func applyNullMissing(obj *objectNode, keys []string) {
+	for _, key := range keys {
+		if hasKey(obj, key) {
+			continue
+		}
+		vn := valueNodePool.Get().(*valueNode)
+		vn.kind = kindNull
+		vn.str = ""
+		vn.num = ""
+		obj.entries = append(obj.entries, objectEntry{key: key, value: vn})
+	}
+}
+
+func hasKey(obj *objectNode, key string) bool {
+	for _, entry := range obj.entries {
+		if entry.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// cloneNode deep-copies n using the node pools so the copy can be recycled
+// with the record it is attached to.
+func cloneNode(n node) node {
+	switch v := n.(type) {
+	case *valueNode:
+		vn := valueNodePool.Get().(*valueNode)
+		*vn = *v
+		return vn
+	case *objectNode:
+		obj := objectNodePool.Get().(*objectNode)
+		obj.entries = obj.entries[:0]
+		for _, entry := range v.entries {
+			obj.entries = append(obj.entries, objectEntry{key: entry.key, value: cloneNode(entry.value)})
+		}
+		return obj
+	case *arrayNode:
+		arr := arrayNodePool.Get().(*arrayNode)
+		arr.values = arr.values[:0]
+		for _, child := range v.values {
+			arr.values = append(arr.values, cloneNode(child))
+		}
+		return arr
+	}
+	return n
+}
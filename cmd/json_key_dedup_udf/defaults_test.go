@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDefaultsFillOnlyMissingKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "defaults.json")
+	if err := os.WriteFile(path, []byte(`{"country":"unknown","tags":[],"id":0}`), 0o644); err != nil {
+		t.Fatalf("write defaults: %v", err)
+	}
+	defaults, err := loadObjectFile(path, defaultMaxSafeInt)
+	if err != nil {
+		t.Fatalf("loadObjectFile: %v", err)
+	}
+
+	opts := &options{defaults: defaults}
+	for i := 0; i < 2; i++ {
+		got, err := dedupLine(opts, `{"id":7,"id":8,"name":"x"}`)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want := `{"id":7,"name":"x","country":"unknown","tags":[]}`; got != want {
+			t.Fatalf("run %d: got %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestEnrichFillAndOverwrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "enrich.json")
+	if err := os.WriteFile(path, []byte(`{"source":"batch-7","env":{"dc":"eu"},"id":0}`), 0o644); err != nil {
+		t.Fatalf("write enrich: %v", err)
+	}
+	enrich, err := loadObjectFile(path, defaultMaxSafeInt)
+	if err != nil {
+		t.Fatalf("loadObjectFile: %v", err)
+	}
+
+	tests := []struct {
+		opts  options
+		input string
+		want  string
+	}{
+		{options{enrich: enrich}, `{"id":7,"id":8,"source":"app"}`, `{"id":7,"source":"app","env":{"dc":"eu"}}`},
+		{options{enrich: enrich, enrichOverwrite: true}, `{"id":7,"id":8,"source":"app"}`, `{"id":0,"source":"batch-7","env":{"dc":"eu"}}`},
+		{options{enrich: enrich, selectPaths: stringList{"id"}}, `{"id":7,"x":1}`, `{"id":7,"source":"batch-7","env":{"dc":"eu"}}`},
+		{options{enrich: enrich, enrichOverwrite: true}, `[1]`, `[1]`},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 2; i++ {
+			got, err := dedupLine(&tt.opts, tt.input)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Fatalf("overwrite=%v run %d: %s = %s, want %s", tt.opts.enrichOverwrite, i, tt.input, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestLoadObjectFileRejectsNonObject(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "defaults.json")
+	if err := os.WriteFile(path, []byte(`[1,2]`), 0o644); err != nil {
+		t.Fatalf("write defaults: %v", err)
+	}
+	if _, err := loadObjectFile(path, defaultMaxSafeInt); err == nil {
+		t.Fatal("expected error for non-object defaults, got nil")
+	}
+}
+
+func TestNullMissingInsertsAbsentKeys(t *testing.T) {
+	opts := &options{nullMissing: stringList{"id", "email", "tags"}}
+	got, err := dedupLine(opts, `{"id":1,"id":2,"tags":[],"name":"x"}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := `{"id":1,"tags":[],"name":"x","email":null}`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// outputFormat is a parsed -out-format template: literal text with {path}
+// placeholders. "{$}" names the whole record, and "{{" and "}}" are literal
+// braces.
+type outputFormat struct {
+	parts []formatPart
+}
+
+type formatPart struct {
+	literal string
+	path    string
+	isPath  bool
+}
+
+func parseOutputFormat(s string) (*outputFormat, error) {
+	f := &outputFormat{}
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			f.parts = append(f.parts, formatPart{literal: literal.String()})
+			literal.Reset()
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '{' && i+1 < len(s) && s[i+1] == '{':
+			literal.WriteByte('{')
+			i++
+		case c == '}' && i+1 < len(s) && s[i+1] == '}':
+			literal.WriteByte('}')
+			i++
+		case c == '{':
+			end := strings.IndexAny(s[i+1:], "{}")
+			if end < 0 || s[i+1+end] != '}' {
+				return nil, fmt.Errorf("unclosed { at offset %d", i)
+			}
+			path := s[i+1 : i+1+end]
+			if path == "" {
+				return nil, fmt.Errorf("empty placeholder at offset %d", i)
+			}
+			flush()
+			f.parts = append(f.parts, formatPart{path: path, isPath: true})
+			i += end + 1
+		case c == '}':
+			return nil, fmt.Errorf("unmatched } at offset %d (write }} for a literal brace)", i)
+		default:
+			literal.WriteByte(c)
+		}
+	}
+	flush()
+	return f, nil
+}
+
+// render writes the template filled from n to buf. Strings are inserted
+// without quotes, with backslashes and control characters escaped as in JSON
+// so a value cannot split the record; other scalars are written as their
+// JSON text, and objects and arrays as compact JSON. Missing paths render as
+// missing.
+func (f *outputFormat) render(buf *bytes.Buffer, n node, missing string) {
+	for _, part := range f.parts {
+		if !part.isPath {
+			buf.WriteString(part.literal)
+			continue
+		}
+		value := n
+		if part.path != templatePathPrefix {
+			value = lookupPath(n, part.path)
+		}
+		switch v := value.(type) {
+		case nil:
+			buf.WriteString(missing)
+		case *valueNode:
+			if v.kind == kindString {
+				writeEscaped(buf, v.str, false)
+			} else {
+				v.Write(buf)
+			}
+		default:
+			v.Write(buf)
+		}
+	}
+}
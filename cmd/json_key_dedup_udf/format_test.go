@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRunOutFormat(t *testing.T) {
+	tests := []struct {
+		format  string
+		missing string
+		want    string
+	}{
+		{"{host} - {req.method} {req.path} {status}", "", "web-1 - GET /a b 200\nweb-2 - POST  500\n"},
+		{"{host} {req.path}", "-", "web-1 /a b\nweb-2 -\n"},
+		{"{{{host}}} {req}", "", "{web-1} {\"method\":\"GET\",\"path\":\"/a b\"}\n{web-2} {\"method\":\"POST\"}\n"},
+		{"{ok},{tags}", "", "true,[1,\"x\"]\nnull,\n"},
+		{"{host}: {note}", "", "web-1: line one\\nline \"two\"\\tC:\\\\x\nweb-2: \n"},
+	}
+	input := "{\"host\":\"web-1\",\"host\":\"web-9\",\"note\":\"line one\\nline \\\"two\\\"\\tC:\\\\x\",\"req\":{\"method\":\"GET\",\"path\":\"/a b\"},\"status\":200,\"ok\":true,\"tags\":[1,\"x\"]}\n" +
+		"{\"host\":\"\",\"host\":\"web-2\",\"req.method\":\"POST\",\"status\":500,\"ok\":null}\n"
+	for _, tt := range tests {
+		opts := &options{outFormat: tt.format, outFormatMissing: tt.missing}
+		if err := opts.load(); err != nil {
+			t.Fatalf("%q: load: %v", tt.format, err)
+		}
+		var out bytes.Buffer
+		if err := run(strings.NewReader(input), &out, opts); err != nil {
+			t.Fatalf("%q: run: %v", tt.format, err)
+		}
+		if got := out.String(); got != tt.want {
+			t.Fatalf("%q: output = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestParseOutputFormatErrors(t *testing.T) {
+	for _, format := range []string{"{a", "a}", "{}", "{a{b}}"} {
+		if _, err := parseOutputFormat(format); err == nil {
+			t.Fatalf("parseOutputFormat(%q): expected error, got nil", format)
+		}
+	}
+}
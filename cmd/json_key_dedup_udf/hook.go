@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// postCmd pipes output through an external command for -post-cmd. The
+// command is started once; records are written to its stdin and whatever it
+// prints on stdout goes to the real output, so ordering is the command's.
+type postCmd struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+// startPostCmd runs command, split on whitespace without shell quoting, with
+// its stdout connected to out and its stderr to ours.
+func startPostCmd(command string, out io.Writer) (*postCmd, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("invalid -post-cmd %q: empty command", command)
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("post-cmd start error: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("post-cmd start error: %w", err)
+	}
+	return &postCmd{cmd: cmd, stdin: stdin}, nil
+}
+
+func (p *postCmd) Write(b []byte) (int, error) {
+	n, err := p.stdin.Write(b)
+	if err != nil {
+		return n, fmt.Errorf("post-cmd write error: %w", err)
+	}
+	return n, nil
+}
+
+// Close ends the command's input and waits for it to exit, reporting a
+// non-zero exit status as an error.
+func (p *postCmd) Close() error {
+	closeErr := p.stdin.Close()
+	if err := p.cmd.Wait(); err != nil {
+		return fmt.Errorf("post-cmd %s failed: %w", p.cmd.Path, err)
+	}
+	return closeErr
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestPostCmdPipesRecordsInOrder(t *testing.T) {
+	if _, err := exec.LookPath("cat"); err != nil {
+		t.Skip("cat not available")
+	}
+	var out bytes.Buffer
+	hook, err := startPostCmd("cat", &out)
+	if err != nil {
+		t.Fatalf("startPostCmd: %v", err)
+	}
+	input := "{\"a\":1,\"a\":2}\n{\"b\":\"\",\"b\":\"x\"}\n{\"c\":3}\n"
+	if err := run(strings.NewReader(input), hook, &options{}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if err := hook.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if got, want := out.String(), "{\"a\":1}\n{\"b\":\"x\"}\n{\"c\":3}\n"; got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+}
+
+func TestPostCmdTransformsRecords(t *testing.T) {
+	if _, err := exec.LookPath("tr"); err != nil {
+		t.Skip("tr not available")
+	}
+	var out bytes.Buffer
+	hook, err := startPostCmd("tr a-z A-Z", &out)
+	if err != nil {
+		t.Fatalf("startPostCmd: %v", err)
+	}
+	if err := run(strings.NewReader("{\"k\":\"v\",\"k\":\"w\"}\n"), hook, &options{}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if err := hook.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if got, want := out.String(), "{\"K\":\"V\"}\n"; got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+}
+
+func TestPostCmdReportsFailure(t *testing.T) {
+	if _, err := exec.LookPath("false"); err != nil {
+		t.Skip("false not available")
+	}
+	hook, err := startPostCmd("false", &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("startPostCmd: %v", err)
+	}
+	if err := hook.Close(); err == nil || !strings.Contains(err.Error(), "exit status 1") {
+		t.Fatalf("close error = %v, want exit status 1", err)
+	}
+	if _, err := startPostCmd("  ", &bytes.Buffer{}); err == nil {
+		t.Fatal("expected error for empty command, got nil")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// rewriteKeys applies the configured key rewrites to the entries of o. It
+// runs before dotted expansion and duplicate detection, so rewritten keys
+// that collide are resolved by the normal dedup rule.
+func (o *objectNode) rewriteKeys(ctx *dedupContext) {
+	if ctx.opts.lowercaseKeys {
+		for i := range o.entries {
+			o.entries[i].key = strings.ToLower(o.entries[i].key)
+		}
+	}
+	if ctx.opts.collapseSpaceKeys {
+		for i := range o.entries {
+			o.entries[i].key = collapseWhitespace(o.entries[i].key, ctx.opts.collapseSpaceTrim)
+		}
+	}
+	switch ctx.opts.keyCase {
+	case keyCaseSnake:
+		for i := range o.entries {
+			o.entries[i].key = toSnakeCase(o.entries[i].key)
+		}
+	case keyCaseCamel:
+		for i := range o.entries {
+			o.entries[i].key = toCamelCase(o.entries[i].key)
+		}
+	}
+}
+
+// affixKeys applies -key-prefix/-key-suffix and then -max-string-len-keys.
+// It runs after dotted expansion, so each path segment is rewritten once, at
+// its own depth, and before duplicate detection.
+func (o *objectNode) affixKeys(ctx *dedupContext) {
+	if (ctx.opts.keyPrefix != "" || ctx.opts.keySuffix != "") &&
+		(ctx.opts.keyAffixDepth == 0 || ctx.depth <= ctx.opts.keyAffixDepth) {
+		for i := range o.entries {
+			o.entries[i].key = ctx.opts.keyPrefix + o.entries[i].key + ctx.opts.keySuffix
+		}
+	}
+	if ctx.opts.maxStringKeys && ctx.opts.maxStringLen > 0 {
+		for i := range o.entries {
+			o.entries[i].key = truncateString(o.entries[i].key, ctx.opts.maxStringLen, ctx.opts.maxStringRunes, ctx.opts.maxStringMarker)
+		}
+	}
+}
+
+// toSnakeCase lowercases key, inserting an underscore at each lower-to-upper
+// boundary and before the last capital of an acronym (HTTPServer becomes
+// http_server).
+func toSnakeCase(key string) string {
+	runes := []rune(key)
+	var b strings.Builder
+	b.Grow(len(key) + 4)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				prev := runes[i-1]
+				if unicode.IsLower(prev) || unicode.IsDigit(prev) ||
+					(unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+					b.WriteByte('_')
+				}
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// toCamelCase drops each underscore and capitalizes the character after it.
+// Leading underscores, such as in _id, are kept.
+func toCamelCase(key string) string {
+	var b strings.Builder
+	b.Grow(len(key))
+	leading, upper := true, false
+	for _, r := range key {
+		if r == '_' && !leading {
+			upper = true
+			continue
+		}
+		if r != '_' {
+			leading = false
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// sortKeys sorts the keys of every object in n, including objects inside
+// arrays. When sortTop is false the keys of n itself keep source order.
+func sortKeys(n node, sortTop bool) {
+	switch v := n.(type) {
+	case *objectNode:
+		if sortTop {
+			sort.SliceStable(v.entries, func(i, j int) bool {
+				return v.entries[i].key < v.entries[j].key
+			})
+		}
+		for _, entry := range v.entries {
+			sortKeys(entry.value, true)
+		}
+	case *arrayNode:
+		for _, item := range v.values {
+			sortKeys(item, true)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import "testing"
+
+func TestLowercaseKeys(t *testing.T) {
+	opts := &options{lowercaseKeys: true}
+	tests := map[string]string{
+		`{"Name":"x","Nested":{"InnerKey":[{"DeepKey":1}]}}`: `{"name":"x","nested":{"innerkey":[{"deepkey":1}]}}`,
+		`{"ID":"","id":"a","Id":"b"}`:                        `{"id":"a"}`,
+		`{"User.Name":"x","user":{"name":""}}`:               `{"user":{"name":"x"}}`,
+	}
+	for input, want := range tests {
+		got, err := dedupLine(opts, input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", input, err)
+		}
+		if got != want {
+			t.Fatalf("%s = %s, want %s", input, got, want)
+		}
+	}
+}
+
+func TestKeyCase(t *testing.T) {
+	tests := []struct {
+		keyCase string
+		input   string
+		want    string
+	}{
+		{keyCaseSnake, `{"userId":1,"HTTPServer":{"maxConns":2},"list":[{"firstName":"x"}]}`, `{"user_id":1,"http_server":{"max_conns":2},"list":[{"first_name":"x"}]}`},
+		{keyCaseSnake, `{"user_id":"","userId":"a","UserID":"b"}`, `{"user_id":"a"}`},
+		{keyCaseSnake, `{"user.firstName":"x","v2Name":1}`, `{"user":{"first_name":"x"},"v2_name":1}`},
+		{keyCaseCamel, `{"user_id":1,"_id":2,"max__conns":3,"nested_obj":{"first_name":"x"}}`, `{"userId":1,"_id":2,"maxConns":3,"nestedObj":{"firstName":"x"}}`},
+		{keyCaseCamel, `{"userId":null,"user_id":"a"}`, `{"userId":"a"}`},
+		{keyCaseNone, `{"userId":1,"user_id":2}`, `{"userId":1,"user_id":2}`},
+	}
+	for _, tt := range tests {
+		got, err := dedupLine(&options{keyCase: tt.keyCase}, tt.input)
+		if err != nil {
+			t.Fatalf("%s %s: unexpected error: %v", tt.keyCase, tt.input, err)
+		}
+		if got != tt.want {
+			t.Fatalf("%s %s = %s, want %s", tt.keyCase, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestKeyPrefixAndSuffix(t *testing.T) {
+	tests := []struct {
+		opts  options
+		input string
+		want  string
+	}{
+		{options{keyPrefix: "src_", keyAffixDepth: 1}, `{"a":1,"b":{"c":2}}`, `{"src_a":1,"src_b":{"c":2}}`},
+		{options{keySuffix: "_v1", keyAffixDepth: 1}, `{"a":1,"b":{"c":2}}`, `{"a_v1":1,"b_v1":{"c":2}}`},
+		{options{keyPrefix: "s_", keySuffix: "_1", keyAffixDepth: 0}, `{"a":[{"b":1}],"c":{"d":2}}`, `{"s_a_1":[{"s_b_1":1}],"s_c_1":{"s_d_1":2}}`},
+		{options{keyPrefix: "src_", keyAffixDepth: 1}, `{"a":"","a":"x"}`, `{"src_a":"x"}`},
+		{options{keyPrefix: "src_", keyAffixDepth: 1, lowercaseKeys: true}, `{"ID":null,"id":7}`, `{"src_id":7}`},
+		{options{keySuffix: "_v1", keyAffixDepth: 0}, `{"a.b":1}`, `{"a_v1":{"b_v1":1}}`},
+		{options{keySuffix: "_v1", keyAffixDepth: 1}, `{"a.b.c":1,"a":{"d":2}}`, `{"a_v1":{"b":{"c":1},"d":2}}`},
+		{options{keyPrefix: "p_", keyAffixDepth: 2}, `{"x.y.z":1}`, `{"p_x":{"p_y":{"z":1}}}`},
+	}
+	for _, tt := range tests {
+		got, err := dedupLine(&tt.opts, tt.input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.input, err)
+		}
+		if got != tt.want {
+			t.Fatalf("prefix=%q suffix=%q depth=%d %s = %s, want %s",
+				tt.opts.keyPrefix, tt.opts.keySuffix, tt.opts.keyAffixDepth, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestKeepKeyOrder(t *testing.T) {
+	input := `{"z":1,"b":{"y":[{"d":1,"c":2}],"x":2},"a":[{"q":1,"p":2}],"b":{}}`
+	tests := map[string]string{
+		keyOrderSource:      `{"z":1,"b":{"y":[{"d":1,"c":2}],"x":2},"a":[{"q":1,"p":2}]}`,
+		keyOrderSorted:      `{"a":[{"p":2,"q":1}],"b":{"x":2,"y":[{"c":2,"d":1}]},"z":1}`,
+		keyOrderAlphaNested: `{"z":1,"b":{"x":2,"y":[{"c":2,"d":1}]},"a":[{"p":2,"q":1}]}`,
+	}
+	for order, want := range tests {
+		got, err := dedupLine(&options{keyOrder: order}, input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", order, err)
+		}
+		if got != want {
+			t.Fatalf("%s = %s, want %s", order, got, want)
+		}
+	}
+}
+
+func TestTransformsReachObjectsInNestedArrays(t *testing.T) {
+	tests := []struct {
+		opts  options
+		input string
+		want  string
+	}{
+		{options{}, `[[{"a":null,"a":1}],[[{"b.c":2}]]]`, `[[{"a":1}],[[{"b":{"c":2}}]]]`},
+		{options{keyCase: keyCaseSnake}, `{"l":[[{"userId":1,"user_id":2}]]}`, `{"l":[[{"user_id":1}]]}`},
+		{options{lowercaseKeys: true}, `{"l":[[[{"A":1,"a":2}]]]}`, `{"l":[[[{"a":1}]]]}`},
+		{options{collapseSpace: true, collapseSpaceTrim: true}, `{"l":[["  a  b ",{"k":"  ","k":" x "}]]}`, `{"l":[["a b",{"k":"x"}]]}`},
+		{options{normalizeBools: stringList{"*"}}, `{"l":[[{"f":"TRUE"}]]}`, `{"l":[[{"f":true}]]}`},
+		{options{keyOrder: keyOrderSorted}, `{"l":[[{"b":1,"a":2}]]}`, `{"l":[[{"a":2,"b":1}]]}`},
+	}
+	for _, tt := range tests {
+		got, err := dedupLine(&tt.opts, tt.input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.input, err)
+		}
+		if got != tt.want {
+			t.Fatalf("%s = %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
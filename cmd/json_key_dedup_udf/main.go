@@ -7,15 +7,45 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"regexp"
 	"runtime/pprof"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/valyala/fastjson"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/unicode/norm"
 )
 
 type node interface {
 	Write(*bytes.Buffer)
-	Dedup() node
+	Dedup(*dedupContext) (node, error)
+}
+
+// dedupContext carries the run options and per-record counters through Dedup.
+type dedupContext struct {
+	opts       *options
+	removed    int
+	candidates []int
+	scratch    bytes.Buffer
+	// depth is the object nesting level being deduplicated; the top-level
+	// object is 1.
+	depth int
+	// skipRecord is set by processLine when the record produces no output.
+	skipRecord bool
+	// parser is reused for every record. convertFastJSON copies all strings
+	// out of the parsed value, so no node aliases parser memory.
+	parser fastjson.Parser
+	// trace receives the -trace dumps; nil disables tracing.
+	trace io.Writer
+	// original holds the compact input record for -changed-only.
+	original bytes.Buffer
+	// formatted holds the -out-format rendering of the current record.
+	formatted bytes.Buffer
+	// audit collects -collision-audit counts; nil disables it.
+	audit collisionAudit
 }
 
 type valueKind int
@@ -30,8 +60,10 @@ const (
 type valueNode struct {
 	kind valueKind
 	str  string
-	num  string
-	b    bool
+	// num is the number token. Strings keep it too when they hold an integer
+	// stringified by -max-safe-int, so later steps can leave it whole.
+	num string
+	b   bool
 }
 
 func (v *valueNode) Write(buf *bytes.Buffer) {
@@ -51,8 +83,33 @@ func (v *valueNode) Write(buf *bytes.Buffer) {
 	}
 }
 
-func (v *valueNode) Dedup() node {
-	return v
+func (v *valueNode) Dedup(ctx *dedupContext) (node, error) {
+	if v.kind == kindNull && ctx.opts.emptyArray == emptyArrayFromNull {
+		valueNodePool.Put(v)
+		arr := arrayNodePool.Get().(*arrayNode)
+		arr.values = arr.values[:0]
+		return arr, nil
+	}
+	if v.kind == kindString {
+		v.str = normalizeString(v.str, ctx.opts)
+	}
+	if v.kind == kindNumber && ctx.opts.normalizeScientific {
+		plain, err := expandScientific(v.num)
+		switch {
+		case err == nil && shouldStringifyNumber(plain, ctx.opts.safeIntDigits()):
+			// 1e20 only becomes an integer here, after the -max-safe-int
+			// check in convertFastJSON, so apply the bound again.
+			v.kind, v.str, v.num = kindString, plain, plain
+		case err == nil:
+			v.num = plain
+		case ctx.opts.scientificStrict:
+			return nil, err
+		}
+	}
+	if v.kind == kindNumber && ctx.opts.negativeZero && isNegativeZero(v.num) {
+		v.num = v.num[1:]
+	}
+	return v, nil
 }
 
 type objectEntry struct {
@@ -62,12 +119,20 @@ type objectEntry struct {
 
 type objectNode struct {
 	entries []objectEntry
+	// expanded marks objects synthesized from dotted keys.
+	expanded bool
 }
 
 type entryInfo struct {
+	first         int
 	firstNonEmpty int
 	last          int
+	count         int
+	chosen        int
 	hasNonEmpty   bool
+	resolved      bool
+	// keepAll marks -no-dedup-keys groups, whose occurrences are all kept.
+	keepAll bool
 }
 
 var entryInfoPool = sync.Pool{
@@ -89,49 +154,412 @@ func (o *objectNode) Write(buf *bytes.Buffer) {
 	buf.WriteByte('}')
 }
 
-func (o *objectNode) Dedup() node {
+// spillKeySuffix names the array -spill-duplicates moves dropped occurrences
+// into.
+const spillKeySuffix = "_dups"
+
+func (o *objectNode) Dedup(ctx *dedupContext) (node, error) {
 	if len(o.entries) == 0 {
-		return o
+		return o, nil
 	}
+	ctx.depth++
+	defer func() { ctx.depth-- }()
 
-	o.entries = expandDottedEntries(o.entries)
+	o.rewriteKeys(ctx)
+	o.entries = expandDottedEntries(o.entries, ctx.opts)
+	o.affixKeys(ctx)
 
 	for i := range o.entries {
-		o.entries[i].value = o.entries[i].value.Dedup()
+		child, err := o.entries[i].value.Dedup(ctx)
+		if err != nil {
+			return nil, err
+		}
+		o.entries[i].value = child
+	}
+	if err := o.normalizeEntryValues(ctx); err != nil {
+		return nil, err
+	}
+	if ctx.opts.dedupMaxDepth > 0 && ctx.depth > ctx.opts.dedupMaxDepth {
+		return o, nil
+	}
+	if ctx.opts.dropIdenticalPairs {
+		o.dropIdenticalPairs(ctx)
 	}
 
 	infoMap := entryInfoPool.Get().(map[string]entryInfo)
+	defer releaseEntryInfo(infoMap)
+	hasDuplicates := false
 	for i, entry := range o.entries {
-		info := infoMap[entry.key]
+		group := ctx.groupKey(entry.key)
+		info := infoMap[group]
+		if len(ctx.opts.noDedupKeys) > 0 && matchesKey(ctx.opts.noDedupKeys, entry.key) {
+			info.keepAll = true
+			info.count = 1
+			infoMap[group] = info
+			continue
+		}
 		info.last = i
-		if !info.hasNonEmpty && isNonEmptyValue(entry.value) {
+		info.count++
+		if info.count == 1 {
+			info.first = i
+		} else {
+			hasDuplicates = true
+		}
+		if !info.hasNonEmpty && isNonEmptyValue(entry.value, ctx.opts) {
 			info.hasNonEmpty = true
 			info.firstNonEmpty = i
 		}
-		infoMap[entry.key] = info
+		infoMap[group] = info
+	}
+
+	if hasDuplicates && ctx.audit != nil {
+		for group, info := range infoMap {
+			if info.count > 1 {
+				ctx.audit.add(group, info.count)
+			}
+		}
+	}
+
+	if hasDuplicates && ctx.opts.suffixDuplicates {
+		o.suffixDuplicates(ctx, infoMap)
+		return o, nil
 	}
 
+	if hasDuplicates && (ctx.opts.scalarObjectConflict != conflictDefault || ctx.opts.resolvePolicy != policyDefault) {
+		if err := o.resolveDuplicates(ctx, infoMap); err != nil {
+			return nil, err
+		}
+	}
+
+	var spills map[string]*arrayNode
 	writeIdx := 0
 	for i, entry := range o.entries {
-		info := infoMap[entry.key]
-		keep := false
-		if info.hasNonEmpty {
-			keep = info.firstNonEmpty == i
-		} else {
-			keep = info.last == i
+		group := ctx.groupKey(entry.key)
+		info := infoMap[group]
+		chosen := info.chosen
+		if !info.resolved {
+			chosen = info.defaultChoice(ctx.opts)
 		}
-		if keep {
+		if chosen == i || info.keepAll {
 			o.entries[writeIdx] = entry
 			writeIdx++
+		} else if ctx.opts.spillDuplicates {
+			if spills == nil {
+				spills = make(map[string]*arrayNode)
+			}
+			spill := spills[group]
+			if spill == nil {
+				spill = arrayNodePool.Get().(*arrayNode)
+				spill.values = spill.values[:0]
+				spills[group] = spill
+			}
+			spill.values = append(spill.values, entry.value)
 		}
 	}
+	ctx.removed += len(o.entries) - writeIdx
 	o.entries = o.entries[:writeIdx]
+	if spills != nil {
+		o.insertSpills(ctx, infoMap, spills)
+	}
+	return o, nil
+}
 
+// insertSpills adds each spill array from -spill-duplicates as a key_dups
+// sibling right after the kept occurrence. A name already used in the object
+// is skipped in favour of key_dups_2, key_dups_3, ...
+func (o *objectNode) insertSpills(ctx *dedupContext, infoMap map[string]entryInfo, spills map[string]*arrayNode) {
+	entries := make([]objectEntry, 0, len(o.entries)+len(spills))
+	for _, entry := range o.entries {
+		entries = append(entries, entry)
+		spill := spills[ctx.groupKey(entry.key)]
+		if spill == nil {
+			continue
+		}
+		name := entry.key + spillKeySuffix
+		for n := 2; ; n++ {
+			if _, taken := infoMap[ctx.groupKey(name)]; !taken {
+				break
+			}
+			name = entry.key + spillKeySuffix + "_" + strconv.Itoa(n)
+		}
+		infoMap[ctx.groupKey(name)] = entryInfo{count: 1}
+		entries = append(entries, objectEntry{key: name, value: spill})
+	}
+	o.entries = entries
+}
+
+// dropIdenticalPairs removes entries whose key and serialized value both
+// match an earlier entry, leaving duplicates with differing values for the
+// selection rule. Strings compare decoded, so "\u00e9" and "é" are equal;
+// -unicode-normalize-equal also folds them under NFC.
+func (o *objectNode) dropIdenticalPairs(ctx *dedupContext) {
+	seen := make(map[string]struct{}, len(o.entries))
+	writeIdx := 0
+	for _, entry := range o.entries {
+		ctx.scratch.Reset()
+		ctx.scratch.WriteString(entry.key)
+		ctx.scratch.WriteByte(0)
+		entry.value.Write(&ctx.scratch)
+		id := ctx.scratch.String()
+		if ctx.opts.unicodeEqual {
+			id = norm.NFC.String(id)
+		}
+		if _, dup := seen[id]; dup {
+			recycleNode(entry.value)
+			continue
+		}
+		seen[id] = struct{}{}
+		o.entries[writeIdx] = entry
+		writeIdx++
+	}
+	ctx.removed += len(o.entries) - writeIdx
+	o.entries = o.entries[:writeIdx]
+}
+
+// groupKey returns the key duplicates are detected under. It differs from
+// the emitted key only when -normalize-underscores is set.
+func (ctx *dedupContext) groupKey(key string) string {
+	if ctx.opts.normalizeUnderscores {
+		return strings.Trim(key, "_")
+	}
+	return key
+}
+
+// defaultChoice returns the entry index kept by the default rule: the first
+// non-empty occurrence, else the last, or simply the first occurrence under
+// -ignore-empty-heuristic.
+func (info entryInfo) defaultChoice(opts *options) int {
+	if opts.ignoreEmptyHeuristic {
+		return info.first
+	}
+	if info.hasNonEmpty {
+		return info.firstNonEmpty
+	}
+	return info.last
+}
+
+func releaseEntryInfo(infoMap map[string]entryInfo) {
 	for key := range infoMap {
 		delete(infoMap, key)
 	}
 	entryInfoPool.Put(infoMap)
-	return o
+}
+
+// suffixDuplicates keeps every occurrence of a duplicated key, renaming the
+// second and later ones to key_2, key_3, ... Suffixes already used by another
+// key in the object are skipped.
+func (o *objectNode) suffixDuplicates(ctx *dedupContext, infoMap map[string]entryInfo) {
+	seen := make(map[string]int)
+	for i := range o.entries {
+		key := o.entries[i].key
+		group := ctx.groupKey(key)
+		if infoMap[group].count < 2 {
+			continue
+		}
+		seen[group]++
+		if seen[group] == 1 {
+			continue
+		}
+		for {
+			renamed := key + "_" + strconv.Itoa(seen[group])
+			if _, taken := infoMap[ctx.groupKey(renamed)]; !taken {
+				o.entries[i].key = renamed
+				infoMap[ctx.groupKey(renamed)] = entryInfo{count: 1}
+				break
+			}
+			seen[group]++
+		}
+	}
+}
+
+// resolveDuplicates applies the configured policies to every duplicated key,
+// marking the chosen entry in infoMap. Keys no policy applies to keep the
+// default rule.
+func (o *objectNode) resolveDuplicates(ctx *dedupContext, infoMap map[string]entryInfo) error {
+	for i, entry := range o.entries {
+		group := ctx.groupKey(entry.key)
+		info := infoMap[group]
+		if info.count < 2 || info.resolved {
+			continue
+		}
+
+		ctx.candidates = ctx.candidates[:0]
+		for j := i; j < len(o.entries); j++ {
+			if ctx.groupKey(o.entries[j].key) == group {
+				ctx.candidates = append(ctx.candidates, j)
+			}
+		}
+
+		chosen, ok, err := resolveScalarObjectConflict(o.entries, ctx.candidates, ctx.opts)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			chosen, ok = ctx.resolveByPolicy(o.entries, ctx.candidates)
+		}
+		if ok {
+			info.chosen = chosen
+		} else {
+			info.chosen = info.defaultChoice(ctx.opts)
+		}
+		info.resolved = true
+		infoMap[group] = info
+	}
+	return nil
+}
+
+// resolveScalarObjectConflict picks a candidate when a duplicate key holds
+// both containers (objects or arrays) and scalars. It reports false when the
+// candidates do not mix kinds or the policy defers to the default rule.
+// Under -tie-break last the latest qualifying container or scalar is kept
+// instead of the earliest.
+func resolveScalarObjectConflict(entries []objectEntry, candidates []int, opts *options) (int, bool, error) {
+	firstContainer, lastContainer := -1, -1
+	firstScalar, lastScalar := -1, -1
+	firstNonEmptyScalar, lastNonEmptyScalar := -1, -1
+	for _, idx := range candidates {
+		if isContainer(entries[idx].value) {
+			if firstContainer < 0 {
+				firstContainer = idx
+			}
+			lastContainer = idx
+			continue
+		}
+		if firstScalar < 0 {
+			firstScalar = idx
+		}
+		lastScalar = idx
+		if isNonEmptyValue(entries[idx].value, opts) {
+			if firstNonEmptyScalar < 0 {
+				firstNonEmptyScalar = idx
+			}
+			lastNonEmptyScalar = idx
+		}
+	}
+	if firstContainer < 0 || lastScalar < 0 {
+		return 0, false, nil
+	}
+
+	last := opts.tieBreak == tieBreakLast
+	switch opts.scalarObjectConflict {
+	case conflictKeepObject:
+		if last {
+			return lastContainer, true, nil
+		}
+		return firstContainer, true, nil
+	case conflictKeepScalar:
+		if opts.ignoreEmptyHeuristic {
+			if last {
+				return lastScalar, true, nil
+			}
+			return firstScalar, true, nil
+		}
+		if firstNonEmptyScalar >= 0 {
+			if last {
+				return lastNonEmptyScalar, true, nil
+			}
+			return firstNonEmptyScalar, true, nil
+		}
+		return lastScalar, true, nil
+	case conflictError:
+		return 0, false, fmt.Errorf("duplicate key %q mixes object/array and scalar values", entries[candidates[0]].key)
+	}
+	return 0, false, nil
+}
+
+// resolveByPolicy picks the candidate preferred by -resolve-policy. Empty
+// values only compete when every candidate is empty, as in the default rule.
+// Ties keep the earliest candidate, or the latest under -tie-break last.
+func (ctx *dedupContext) resolveByPolicy(entries []objectEntry, candidates []int) (int, bool) {
+	switch ctx.opts.resolvePolicy {
+	case policyDefault:
+		return 0, false
+	case policyNumMax, policyNumMin:
+		return ctx.resolveNumeric(entries, ctx.preferNonEmpty(entries, candidates))
+	}
+	candidates = ctx.preferNonEmpty(entries, candidates)
+
+	best, bestSize := -1, 0
+	for _, idx := range candidates {
+		ctx.scratch.Reset()
+		entries[idx].value.Write(&ctx.scratch)
+		size := ctx.scratch.Len()
+		diff := size - bestSize
+		if ctx.opts.resolvePolicy == policySmallest {
+			diff = -diff
+		}
+		if best < 0 || ctx.beats(diff) {
+			best, bestSize = idx, size
+		}
+	}
+	return best, best >= 0
+}
+
+// resolveNumeric picks the largest (or smallest) number among candidates,
+// compared exactly. It reports false unless every candidate is a number.
+func (ctx *dedupContext) resolveNumeric(entries []objectEntry, candidates []int) (int, bool) {
+	best := -1
+	var bestNum exactNumber
+	for _, idx := range candidates {
+		vn, ok := entries[idx].value.(*valueNode)
+		if !ok || vn.kind != kindNumber {
+			return 0, false
+		}
+		num, err := parseExactNumber(vn.num)
+		if err != nil {
+			return 0, false
+		}
+		if best < 0 {
+			best, bestNum = idx, num
+			continue
+		}
+		diff := num.cmp(bestNum)
+		if ctx.opts.resolvePolicy == policyNumMin {
+			diff = -diff
+		}
+		if ctx.beats(diff) {
+			best, bestNum = idx, num
+		}
+	}
+	return best, best >= 0
+}
+
+// beats reports whether a later candidate replaces the current best, given
+// diff > 0 when the candidate ranks higher and diff == 0 on a tie.
+func (ctx *dedupContext) beats(diff int) bool {
+	return diff > 0 || (diff == 0 && ctx.opts.tieBreak == tieBreakLast)
+}
+
+// preferNonEmpty narrows candidates to the non-empty ones, unless none or all
+// of them are empty or -ignore-empty-heuristic is set. It filters in place.
+func (ctx *dedupContext) preferNonEmpty(entries []objectEntry, candidates []int) []int {
+	if ctx.opts.ignoreEmptyHeuristic {
+		return candidates
+	}
+	nonEmpty := 0
+	for _, idx := range candidates {
+		if isNonEmptyValue(entries[idx].value, ctx.opts) {
+			nonEmpty++
+		}
+	}
+	if nonEmpty == 0 || nonEmpty == len(candidates) {
+		return candidates
+	}
+	filtered := candidates[:0]
+	for _, idx := range candidates {
+		if isNonEmptyValue(entries[idx].value, ctx.opts) {
+			filtered = append(filtered, idx)
+		}
+	}
+	return filtered
+}
+
+func isContainer(n node) bool {
+	switch n.(type) {
+	case *objectNode, *arrayNode:
+		return true
+	}
+	return false
 }
 
 type mergeKey struct {
@@ -145,10 +573,13 @@ var dottedIndexPool = sync.Pool{
 	},
 }
 
-func expandDottedEntries(entries []objectEntry) []objectEntry {
+// expandDottedEntries turns dotted keys into nested objects. When
+// -expand-keys lists prefixes only keys starting with one of them are
+// expanded; other dotted keys stay literal.
+func expandDottedEntries(entries []objectEntry, opts *options) []objectEntry {
 	needsExpand := false
 	for _, entry := range entries {
-		if indexByte(entry.key, '.') >= 0 {
+		if shouldExpandKey(entry.key, opts.expandKeys) {
 			needsExpand = true
 			break
 		}
@@ -158,48 +589,88 @@ func expandDottedEntries(entries []objectEntry) []objectEntry {
 	}
 
 	expanded := make([]objectEntry, 0, len(entries))
-	index := dottedIndexPool.Get().(map[mergeKey]*objectNode)
+	e := dottedExpander{
+		index:             dottedIndexPool.Get().(map[mergeKey]*objectNode),
+		preserveAmbiguous: opts.preserveAmbiguous,
+	}
 	for _, entry := range entries {
-		if indexByte(entry.key, '.') < 0 {
-			appendEntry(nil, &expanded, entry.key, entry.value, index)
+		if !shouldExpandKey(entry.key, opts.expandKeys) {
+			e.appendEntry(nil, &expanded, entry.key, entry.value)
 			continue
 		}
-		insertDottedKey(nil, &expanded, entry.key, entry.value, index)
+		e.insertDottedKey(nil, &expanded, entry.key, entry.value)
 	}
 
-	for key := range index {
-		delete(index, key)
+	for key := range e.index {
+		delete(e.index, key)
 	}
-	dottedIndexPool.Put(index)
+	dottedIndexPool.Put(e.index)
 
 	return expanded
 }
 
-func appendEntry(parent *objectNode, entries *[]objectEntry, key string, value node, index map[mergeKey]*objectNode) {
-	*entries = append(*entries, objectEntry{key: key, value: value})
+// ambiguousKeySuffix is appended to the key of an object built from dotted
+// keys when -preserve-ambiguous finds a non-object value under the same key.
+const ambiguousKeySuffix = "_expanded"
+
+// dottedExpander holds the state of a single expandDottedEntries call.
+type dottedExpander struct {
+	index             map[mergeKey]*objectNode
+	preserveAmbiguous bool
+}
+
+func (e *dottedExpander) appendEntry(parent *objectNode, entries *[]objectEntry, key string, value node) {
 	mk := mergeKey{parent: parent, key: key}
-	if obj, ok := value.(*objectNode); ok {
-		index[mk] = obj
+	obj, isObject := value.(*objectNode)
+	if e.preserveAmbiguous && !isObject {
+		if target := e.index[mk]; target != nil && target.expanded {
+			renameEntry(*entries, key, target, key+ambiguousKeySuffix)
+			*entries = append(*entries, objectEntry{key: key, value: value})
+			return
+		}
+	}
+
+	// A literal object arriving after dotted keys built one under the same
+	// key is merged into it, recursively, rather than left for the duplicate
+	// rule, which would keep only one of them.
+	if target := e.index[mk]; isObject && target != nil && target.expanded {
+		for _, entry := range obj.entries {
+			e.appendEntry(target, &target.entries, entry.key, entry.value)
+		}
+		obj.entries = obj.entries[:0]
+		objectNodePool.Put(obj)
+		return
+	}
+
+	*entries = append(*entries, objectEntry{key: key, value: value})
+	if isObject {
+		e.index[mk] = obj
 	} else {
-		delete(index, mk)
+		delete(e.index, mk)
 	}
 }
 
-func insertDottedKey(parent *objectNode, entries *[]objectEntry, key string, value node, index map[mergeKey]*objectNode) {
+func (e *dottedExpander) insertDottedKey(parent *objectNode, entries *[]objectEntry, key string, value node) {
 	for {
 		dot := indexByte(key, '.')
 		if dot < 0 {
-			appendEntry(parent, entries, key, value, index)
+			e.appendEntry(parent, entries, key, value)
 			return
 		}
 		head := key[:dot]
 		rest := key[dot+1:]
 		mk := mergeKey{parent: parent, key: head}
-		target := index[mk]
+		target := e.index[mk]
 		if target == nil {
 			target = objectNodePool.Get().(*objectNode)
 			target.entries = target.entries[:0]
-			appendEntry(parent, entries, head, target, index)
+			target.expanded = true
+			if e.preserveAmbiguous && hasNonObjectEntry(*entries, head) {
+				*entries = append(*entries, objectEntry{key: head + ambiguousKeySuffix, value: target})
+				e.index[mk] = target
+			} else {
+				e.appendEntry(parent, entries, head, target)
+			}
 		}
 		parent = target
 		entries = &parent.entries
@@ -207,6 +678,42 @@ func insertDottedKey(parent *objectNode, entries *[]objectEntry, key string, val
 	}
 }
 
+func renameEntry(entries []objectEntry, key string, value node, renamed string) {
+	for i := range entries {
+		if entries[i].key == key && entries[i].value == value {
+			entries[i].key = renamed
+			return
+		}
+	}
+}
+
+func hasNonObjectEntry(entries []objectEntry, key string) bool {
+	for _, entry := range entries {
+		if entry.key != key {
+			continue
+		}
+		if _, ok := entry.value.(*objectNode); !ok {
+			return true
+		}
+	}
+	return false
+}
+
+func shouldExpandKey(key string, prefixes []string) bool {
+	if indexByte(key, '.') < 0 {
+		return false
+	}
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func indexByte(s string, c byte) int {
 	for i := 0; i < len(s); i++ {
 		if s[i] == c {
@@ -231,35 +738,106 @@ func (a *arrayNode) Write(buf *bytes.Buffer) {
 	buf.WriteByte(']')
 }
 
-func (a *arrayNode) Dedup() node {
+func (a *arrayNode) Dedup(ctx *dedupContext) (node, error) {
+	if len(a.values) == 0 && ctx.opts.emptyArray == emptyArrayToNull {
+		arrayNodePool.Put(a)
+		vn := valueNodePool.Get().(*valueNode)
+		vn.kind = kindNull
+		vn.str = ""
+		vn.num = ""
+		return vn, nil
+	}
 	for i := range a.values {
-		a.values[i] = a.values[i].Dedup()
+		child, err := a.values[i].Dedup(ctx)
+		if err != nil {
+			return nil, err
+		}
+		a.values[i] = child
+		if vn, ok := child.(*valueNode); ok {
+			truncateValue(vn, ctx.opts)
+		}
+	}
+	if ctx.opts.arrayDedupBy != "" {
+		a.dedupElements(ctx)
+	}
+	return a, nil
+}
+
+// dedupElements keeps only the first object element for each value of the
+// -array-dedup-by path. Elements without the path, and non-objects, are kept.
+func (a *arrayNode) dedupElements(ctx *dedupContext) {
+	var seen map[string]struct{}
+	writeIdx := 0
+	for _, item := range a.values {
+		if _, ok := item.(*objectNode); ok {
+			if id := lookupPath(item, ctx.opts.arrayDedupBy); id != nil {
+				ctx.scratch.Reset()
+				id.Write(&ctx.scratch)
+				if seen == nil {
+					seen = make(map[string]struct{})
+				}
+				if _, dup := seen[ctx.scratch.String()]; dup {
+					recycleNode(item)
+					ctx.removed++
+					continue
+				}
+				seen[ctx.scratch.String()] = struct{}{}
+			}
+		}
+		a.values[writeIdx] = item
+		writeIdx++
 	}
-	return a
+	a.values = a.values[:writeIdx]
 }
 
-func isNonEmptyValue(n node) bool {
+// isNonEmptyValue reports whether n counts as a real value for the default
+// rule: not null, not an empty string and not one of the -empty-values
+// sentinels, which match string values and number tokens exactly.
+func isNonEmptyValue(n node, opts *options) bool {
 	switch v := n.(type) {
 	case *valueNode:
 		switch v.kind {
 		case kindNull:
 			return false
 		case kindString:
-			return v.str != ""
+			return v.str != "" && !isEmptySentinel(v.str, opts)
+		case kindNumber:
+			return !isEmptySentinel(v.num, opts)
 		default:
 			return true
 		}
+	case *arrayNode:
+		// Under from-null every null has become [], so an empty array stands
+		// for null and must not win over a real value.
+		return len(v.values) > 0 || opts.emptyArray != emptyArrayFromNull
 	default:
 		return true
 	}
 }
 
+func isEmptySentinel(s string, opts *options) bool {
+	for _, sentinel := range opts.emptyValues {
+		if s == sentinel {
+			return true
+		}
+	}
+	return false
+}
+
 func writeJSONString(buf *bytes.Buffer, s string) {
 	buf.WriteByte('"')
+	writeEscaped(buf, s, true)
+	buf.WriteByte('"')
+}
+
+// writeEscaped writes s with backslashes and control characters escaped as
+// in a JSON string, and double quotes too when quote is set. Every -input-delim
+// byte is a control character, so the result never contains one.
+func writeEscaped(buf *bytes.Buffer, s string, quote bool) {
 	start := 0
 	for i := 0; i < len(s); i++ {
 		ch := s[i]
-		if ch >= 0x20 && ch != '\\' && ch != '"' {
+		if ch >= 0x20 && ch != '\\' && (ch != '"' || !quote) {
 			continue
 		}
 		if start < i {
@@ -290,7 +868,18 @@ func writeJSONString(buf *bytes.Buffer, s string) {
 	if start < len(s) {
 		buf.WriteString(s[start:])
 	}
-	buf.WriteByte('"')
+}
+
+// escapeSlashes rewrites every "/" in serialized JSON as "\/". A slash can
+// only occur inside a string in JSON text, so the whole buffer is safe to
+// rewrite.
+func escapeSlashes(buf *bytes.Buffer) {
+	if bytes.IndexByte(buf.Bytes(), '/') < 0 {
+		return
+	}
+	escaped := bytes.ReplaceAll(buf.Bytes(), []byte("/"), []byte(`\/`))
+	buf.Reset()
+	buf.Write(escaped)
 }
 
 var parserPool = sync.Pool{
@@ -328,6 +917,7 @@ func recycleNode(n node) {
 			recycleNode(entry.value)
 		}
 		v.entries = v.entries[:0]
+		v.expanded = false
 		objectNodePool.Put(v)
 	case *arrayNode:
 		for _, child := range v.values {
@@ -338,7 +928,9 @@ func recycleNode(n node) {
 	}
 }
 
-func convertFastJSON(value *fastjson.Value) (node, error) {
+// convertFastJSON converts a parsed value into a node tree. A maxDigits above
+// zero rejects numbers whose mantissa has more digits than that.
+func convertFastJSON(value *fastjson.Value, maxDigits int, maxSafeInt string) (node, error) {
 	switch value.Type() {
 	case fastjson.TypeObject:
 		obj, err := value.Object()
@@ -353,7 +945,7 @@ func convertFastJSON(value *fastjson.Value) (node, error) {
 			objNode.entries = make([]objectEntry, 0, obj.Len())
 		}
 		obj.Visit(func(key []byte, v *fastjson.Value) {
-			child, convErr := convertFastJSON(v)
+			child, convErr := convertFastJSON(v, maxDigits, maxSafeInt)
 			if convErr != nil {
 				err = convErr
 				return
@@ -378,7 +970,7 @@ func convertFastJSON(value *fastjson.Value) (node, error) {
 			arrNode.values = make([]node, 0, len(values))
 		}
 		for _, item := range values {
-			child, convErr := convertFastJSON(item)
+			child, convErr := convertFastJSON(item, maxDigits, maxSafeInt)
 			if convErr != nil {
 				return nil, convErr
 			}
@@ -393,12 +985,17 @@ func convertFastJSON(value *fastjson.Value) (node, error) {
 		vn.num = ""
 		return vn, nil
 	case fastjson.TypeNumber:
+		// fastjson keeps the source token for numbers, so String() returns it
+		// byte for byte (1e400, -0 and long fractions are not reformatted).
 		num := value.String()
+		if maxDigits > 0 && numberDigits(num) > maxDigits {
+			return nil, fmt.Errorf("number %s has more than %d digits", num, maxDigits)
+		}
 		vn := valueNodePool.Get().(*valueNode)
-		if shouldStringifyNumber(num) {
+		if shouldStringifyNumber(num, maxSafeInt) {
 			vn.kind = kindString
 			vn.str = num
-			vn.num = ""
+			vn.num = num
 		} else {
 			vn.kind = kindNumber
 			vn.num = num
@@ -430,7 +1027,63 @@ func convertFastJSON(value *fastjson.Value) (node, error) {
 	}
 }
 
-func shouldStringifyNumber(num string) bool {
+// numberDigits counts the mantissa digits of a JSON number token.
+func numberDigits(num string) int {
+	digits := 0
+	for i := 0; i < len(num); i++ {
+		c := num[i]
+		if c == 'e' || c == 'E' {
+			break
+		}
+		if c >= '0' && c <= '9' {
+			digits++
+		}
+	}
+	return digits
+}
+
+// nodeTypeName names the JSON type of n for error messages.
+func nodeTypeName(n node) string {
+	switch v := n.(type) {
+	case *objectNode:
+		return "object"
+	case *arrayNode:
+		return "array"
+	case *valueNode:
+		switch v.kind {
+		case kindString:
+			return "string"
+		case kindNumber:
+			return "number"
+		case kindBool:
+			return "bool"
+		}
+	}
+	return "null"
+}
+
+func jsonTypeName(t fastjson.Type) string {
+	switch t {
+	case fastjson.TypeTrue, fastjson.TypeFalse:
+		return "bool"
+	default:
+		return t.String()
+	}
+}
+
+// defaultMaxSafeInt is 2^53-1, the largest integer a JavaScript number (or
+// any float64 consumer) represents exactly.
+const defaultMaxSafeInt = "9007199254740991"
+
+// maxSafeIntInt64 selects the asymmetric signed 64-bit range for
+// -max-safe-int, which older versions always used.
+const maxSafeIntInt64 = "int64"
+
+// shouldStringifyNumber reports whether num is an integer whose magnitude
+// exceeds maxSafeInt, given as plain decimal digits, or whether it falls
+// outside the int64 range when maxSafeInt is maxSafeIntInt64. Fractions and
+// exponents are never stringified.
+func shouldStringifyNumber(num, maxSafeInt string) bool {
 	if len(num) == 0 {
 		return false
 	}
@@ -444,8 +1097,7 @@ func shouldStringifyNumber(num string) bool {
 	}
 
 	start := 0
-	neg := num[0] == '-'
-	if neg {
+	if num[0] == '-' {
 		start = 1
 	}
 
@@ -454,55 +1106,320 @@ func shouldStringifyNumber(num string) bool {
 		start++
 	}
 
-	digitLen := len(num) - start
-	if digitLen == 0 {
-		return false // It's just zeros
+	if maxSafeInt == maxSafeIntInt64 {
+		maxSafeInt = "9223372036854775807"
+		if num[0] == '-' {
+			maxSafeInt = "9223372036854775808"
+		}
 	}
 
-	const maxLen = 19 // len("9223372036854775807")
-	if digitLen < maxLen {
-		return false
+	digits := num[start:]
+	if len(digits) != len(maxSafeInt) {
+		return len(digits) > len(maxSafeInt)
 	}
-	if digitLen > maxLen {
-		return true
+	// Same length - compare lexicographically
+	return digits > maxSafeInt
+}
+
+func processLine(rawLine []byte, buf *bytes.Buffer, ctx *dedupContext) error {
+	ctx.removed = 0
+	ctx.skipRecord = false
+
+	if ctx.opts.inputCharset == charsetLatin1 {
+		decoded, err := charmap.ISO8859_1.NewDecoder().Bytes(rawLine)
+		if err != nil {
+			return fmt.Errorf("latin1 decode error: %w", err)
+		}
+		rawLine = decoded
+	}
+	if ctx.opts.rejectNonUTF8 {
+		if offset := invalidUTF8Offset(rawLine); offset >= 0 {
+			return fmt.Errorf("invalid UTF-8 at byte offset %d", offset)
+		}
 	}
 
-	// Exactly maxLen digits - compare lexicographically
-	digits := num[start:]
-	if neg {
-		const minInt64Abs = "9223372036854775808"
-		return digits > minInt64Abs
+	var prefix []byte
+	if ctx.opts.linePrefix != nil {
+		prefix, rawLine = splitLinePrefix(rawLine, ctx.opts.linePrefix)
 	}
-	const maxInt64 = "9223372036854775807"
-	return digits > maxInt64
-}
 
-func processLine(rawLine []byte, buf *bytes.Buffer) error {
-	parser := parserPool.Get().(*fastjson.Parser)
-	defer parserPool.Put(parser)
+	if (ctx.opts.blankLine == blankLineSkip || ctx.opts.blankLine == blankLineEmptyObject) &&
+		len(bytes.TrimSpace(rawLine)) == 0 {
+		buf.Reset()
+		if ctx.opts.blankLine == blankLineSkip {
+			ctx.skipRecord = true
+		} else {
+			buf.WriteString("{}")
+		}
+		return nil
+	}
 
-	value, err := parser.ParseBytes(rawLine)
+	value, err := ctx.parser.ParseBytes(rawLine)
 	if err != nil {
 		return fmt.Errorf("json parse error: %w", err)
 	}
+	if ctx.opts.requireTopObject && value.Type() != fastjson.TypeObject {
+		return fmt.Errorf("expected a top-level JSON object, got %s", jsonTypeName(value.Type()))
+	}
 
-	parsed, err := convertFastJSON(value)
+	if ctx.opts.changedOnly {
+		// Marshal before converting: fastjson still holds the raw string and
+		// number text, so the baseline keeps the input's own spelling.
+		ctx.original.Reset()
+		ctx.original.Write(value.MarshalTo(ctx.original.AvailableBuffer()))
+	}
+	parsed, err := convertFastJSON(value, ctx.opts.maxNumberDigits, ctx.opts.safeIntDigits())
 	if err != nil {
 		return fmt.Errorf("json parse error: %w", err)
 	}
 
-	result := parsed.Dedup()
+	if ctx.trace != nil {
+		writeTrace(ctx.trace, "before dedup", parsed)
+	}
+	result, err := parsed.Dedup(ctx)
+	if err != nil {
+		recycleNode(parsed)
+		return err
+	}
+	if vn, ok := result.(*valueNode); ok {
+		// Nested strings are truncated by their parent; a scalar record has
+		// none.
+		truncateValue(vn, ctx.opts)
+	}
+	if ctx.trace != nil {
+		writeTrace(ctx.trace, "after dedup", result)
+	}
+	if len(ctx.opts.objectElements) > 0 {
+		if err := checkObjectElements(result, ctx.opts.objectElements); err != nil {
+			recycleNode(result)
+			return err
+		}
+	}
+	if obj, ok := result.(*objectNode); ok {
+		if ctx.opts.defaults != nil {
+			applyDefaults(obj, ctx.opts.defaults)
+		}
+		if len(ctx.opts.nullMissing) > 0 {
+			applyNullMissing(obj, ctx.opts.nullMissing)
+		}
+	}
+	if len(ctx.opts.where) > 0 && !matchesWhere(result, ctx.opts.where) {
+		recycleNode(result)
+		buf.Reset()
+		ctx.skipRecord = true
+		return nil
+	}
+
+	output := result
+	if len(ctx.opts.selectPaths) > 0 {
+		output = selectPaths(result, ctx.opts.selectPaths, ctx.opts.selectFlat)
+	} else if ctx.opts.template != nil {
+		output = applyTemplate(result, ctx.opts.template, ctx.opts.templateOmitMissing)
+	}
+	if ctx.opts.enrich != nil {
+		if obj, ok := output.(*objectNode); ok {
+			applyEnrich(obj, ctx.opts.enrich, ctx.opts.enrichOverwrite)
+		}
+	}
+	if ctx.opts.annotateDups {
+		if obj, ok := output.(*objectNode); ok {
+			setDupsRemoved(obj, ctx.removed)
+		}
+	}
+	if len(ctx.opts.idFrom) > 0 {
+		if obj, ok := output.(*objectNode); ok {
+			setRecordID(obj, recordID(result, ctx.opts.idFrom, &ctx.scratch))
+		}
+	}
+
+	if ctx.opts.keyOrder == keyOrderSorted || ctx.opts.keyOrder == keyOrderAlphaNested {
+		sortKeys(output, ctx.opts.keyOrder == keyOrderSorted)
+	}
+
 	buf.Reset()
 	buf.Grow(len(rawLine))
-	result.Write(buf)
+	output.Write(buf)
+	if ctx.opts.outFormatTmpl != nil {
+		ctx.formatted.Reset()
+		ctx.opts.outFormatTmpl.render(&ctx.formatted, output, ctx.opts.outFormatMissing)
+	}
 	recycleNode(result)
+	if ctx.opts.escapeSlash {
+		escapeSlashes(buf)
+	}
+	if ctx.opts.schema != nil {
+		if err := validateRecord(ctx.opts.schema, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	if ctx.opts.dropEmptyRecords && isEmptyContainer(buf.Bytes()) {
+		ctx.skipRecord = true
+	}
+	if ctx.opts.changedOnly && bytes.Equal(buf.Bytes(), ctx.original.Bytes()) {
+		ctx.skipRecord = true
+	}
+	if ctx.opts.outFormatTmpl != nil {
+		buf.Reset()
+		buf.Write(ctx.formatted.Bytes())
+	}
+	if len(prefix) > 0 {
+		ctx.scratch.Reset()
+		ctx.scratch.Write(prefix)
+		ctx.scratch.Write(buf.Bytes())
+		buf.Reset()
+		buf.Write(ctx.scratch.Bytes())
+	}
 	return nil
 }
 
+// splitLinePrefix returns the part of line matched by the -line-prefix-regex
+// at its start, plus any whitespace after it, and the remaining payload.
+func splitLinePrefix(line []byte, re *regexp.Regexp) ([]byte, []byte) {
+	loc := re.FindIndex(line)
+	if loc == nil || loc[0] != 0 {
+		return nil, line
+	}
+	payload := bytes.TrimLeft(line[loc[1]:], " \t")
+	return line[:len(line)-len(payload)], payload
+}
+
+func isEmptyContainer(serialized []byte) bool {
+	s := string(serialized)
+	return s == "{}" || s == "[]"
+}
+
+// registerFlags defines every option flag on fs.
+func registerFlags(fs *flag.FlagSet, opts *options) {
+	fs.BoolVar(&opts.trace, "trace", false, "dump each record's node tree to stderr before and after deduplication")
+	fs.StringVar(&opts.outputURL, "output-url", "", "write output to tcp://host:port or unix:///path instead of stdout")
+	fs.StringVar(&opts.outFormat, "out-format", "", "write each record as a text line from a template with {dotted.path} placeholders ({{ and }} for literal braces) instead of JSON")
+	fs.StringVar(&opts.outFormatMissing, "out-format-missing", "", "with -out-format, text written for a placeholder whose path is missing")
+	fs.StringVar(&opts.postCmd, "post-cmd", "", "pipe output records through this command (split on whitespace, no shell) and write what it prints")
+	fs.IntVar(&opts.batchLines, "batch-lines", 0, "start a new output file every N records (requires -out-pattern)")
+	fs.StringVar(&opts.outPattern, "out-pattern", "", "output file name pattern for -batch-lines with a %d batch number, e.g. out-%d.ndjson")
+	fs.BoolVar(&opts.countOnly, "count-only", false, "print duplicate statistics as JSON at EOF instead of records")
+	fs.StringVar(&opts.inputCharset, "input-charset", charsetUTF8, "input character set: utf-8 (passed through) or latin1 (transcoded to UTF-8 before parsing)")
+	fs.StringVar(&opts.linePrefixRegex, "line-prefix-regex", "", "regular expression matching a non-JSON prefix at the start of each line, which is passed through unchanged")
+	fs.StringVar(&opts.inputFormat, "input-format", inputFormatNDJSON, "input shape: ndjson (one record per line), json (one document; a top-level array yields one record per element) or auto to detect from the first line")
+	fs.BoolVar(&opts.inputJSONArray, "input-json-array", false, "read the whole input as one JSON array and process each element as a record")
+	fs.BoolVar(&opts.escapeSlash, "escape-slash", false, "escape forward slashes in strings as \\/ for legacy consumers")
+	fs.BoolVar(&opts.wrapArray, "wrap-array", false, "write all records as a single JSON array instead of one per line")
+	fs.BoolVar(&opts.annotateDups, "annotate-dups", false, "add a _dups_removed field with the number of duplicates dropped from each object record")
+	fs.StringVar(&opts.collisionAudit, "collision-audit", "", "at the end of the input, write per-key duplicate counts for the whole run to this file (- for stderr), most duplicated first")
+	fs.StringVar(&opts.dedupReport, "dedup-report", "", "write a JSON run summary to this file at exit (- for stderr)")
+	fs.StringVar(&opts.scalarObjectConflict, "scalar-object-conflict", conflictDefault, "policy when a duplicate key mixes object/array and scalar values: keep-object, keep-scalar or error")
+	fs.StringVar(&opts.tieBreak, "tie-break", tieBreakFirst, "which candidate -resolve-policy and -scalar-object-conflict keep on a tie: first or last")
+	fs.StringVar(&opts.resolvePolicy, "resolve-policy", policyDefault, "keep the duplicate with the largest or smallest serialized value, or with numeric-max/numeric-min the largest or smallest number, instead of the first non-empty one")
+	fs.Var(&opts.selectPaths, "select", "comma-separated dotted paths to keep in the output, e.g. a.b,c")
+	fs.BoolVar(&opts.selectFlat, "select-flat", false, "emit -select paths as flat dotted keys instead of nested objects")
+	fs.StringVar(&opts.trailingNewline, "trailing-newline", trailingNewlineAuto, "when to end output records with the delimiter: auto (as the input record was), always or never")
+	fs.StringVar(&opts.blankLine, "blank-line", blankLineError, "handling of blank input lines: skip, empty-object or error")
+	fs.Var(&opts.objectElements, "require-object-elements", "comma-separated dotted paths ($ for the record itself) of arrays whose elements must all be objects")
+	fs.BoolVar(&opts.requireTopObject, "require-top-object", false, "fail lines whose top-level value is not a JSON object")
+	fs.StringVar(&opts.keyOrder, "keep-key-order", keyOrderSource, "output key order: source (first occurrence), sorted (every level) or alpha-nested (nested objects only)")
+	fs.StringVar(&opts.keyCase, "key-case", keyCaseNone, "rewrite object keys at every level to snake_case or camelCase before deduplication: snake, camel or none")
+	fs.BoolVar(&opts.lowercaseKeys, "lowercase-keys", false, "lowercase every object key before deduplication")
+	fs.Var(&opts.idFrom, "id-from", "comma-separated dotted paths hashed into a leading _id field")
+	fs.Var(&opts.expandKeys, "expand-keys", "comma-separated key prefixes; only dotted keys starting with one are expanded")
+	fs.Func("input-delim", "record delimiter byte for input and output: \\n (default), \\0, \\t or \\xNN; must be a control character", func(value string) error {
+		delim, err := parseDelim(value)
+		if err != nil {
+			return err
+		}
+		opts.inputDelim = string([]byte{delim})
+		return nil
+	})
+	fs.Func("where", "only write records whose value at a dotted path equals a literal, as path=value; repeat to require several", func(value string) error {
+		cond, err := parseWhere(value)
+		if err != nil {
+			return err
+		}
+		opts.where = append(opts.where, cond)
+		return nil
+	})
+	fs.BoolVar(&opts.normalizeUnderscores, "normalize-underscores", false, "treat keys differing only by leading/trailing underscores as duplicates")
+	fs.Var(&opts.normalizeBools, "normalize-bools", "comma-separated keys (or *) whose \"true\"/\"false\"/\"1\"/\"0\" string values become booleans")
+	fs.Var(&opts.emptyValues, "empty-values", "comma-separated placeholder values (such as N/A or -) treated as empty by the default rule, matched against strings and number tokens")
+	fs.BoolVar(&opts.ignoreEmptyHeuristic, "ignore-empty-heuristic", false, "keep the first occurrence of a duplicate key even when it is null or empty")
+	fs.BoolVar(&opts.spillDuplicates, "spill-duplicates", false, "keep the chosen occurrence of a duplicated key and move the others into a sibling key_dups array")
+	fs.StringVar(&opts.arrayDedupBy, "array-dedup-by", "", "in arrays, keep only the first object element for each value of this dotted path")
+	fs.BoolVar(&opts.unicodeEqual, "unicode-normalize-equal", false, "with -drop-identical-pairs, compare keys and values under Unicode NFC")
+	fs.Var(&opts.noDedupKeys, "no-dedup-keys", "comma-separated keys whose repeated occurrences are all kept")
+	fs.BoolVar(&opts.dropIdenticalPairs, "drop-identical-pairs", false, "drop repeated entries whose key and value both match an earlier entry before choosing between duplicates")
+	fs.BoolVar(&opts.suffixDuplicates, "suffix-duplicates", false, "keep duplicate keys, renaming later occurrences to key_2, key_3, ...")
+	fs.IntVar(&opts.maxRecordSize, "max-record-size", defaultMaxRecordSize, "maximum size of a single input record in bytes")
+	fs.Var(&opts.normalizeTimestamps, "normalize-timestamps", "comma-separated keys (or *) whose timestamp strings are rewritten as RFC 3339 UTC")
+	fs.BoolVar(&opts.preserveAmbiguous, "preserve-ambiguous", false, "when a dotted key expands onto a non-object value, keep both, moving the expansion to key_expanded")
+	fs.StringVar(&opts.keyPrefix, "key-prefix", "", "prefix added to object keys (top level only unless -key-affix-depth says otherwise)")
+	fs.StringVar(&opts.keySuffix, "key-suffix", "", "suffix added to object keys (top level only unless -key-affix-depth says otherwise)")
+	fs.IntVar(&opts.dedupMaxDepth, "dedup-max-depth", 0, "only deduplicate objects nested at most N levels deep (top level is 1); 0 deduplicates every level")
+	fs.IntVar(&opts.keyAffixDepth, "key-affix-depth", 1, "number of object levels -key-prefix/-key-suffix apply to; 0 means all levels")
+	fs.BoolVar(&opts.emitBOM, "emit-bom", false, "write a UTF-8 byte order mark at the start of the output (of each file with -batch-lines)")
+	fs.StringVar(&opts.emptyArray, "normalize-empty-array", "", "rewrite empty arrays as null (to-null) or null as empty arrays (from-null)")
+	fs.BoolVar(&opts.normalizeScientific, "normalize-scientific", false, "rewrite numbers in scientific notation (1.5e3) as plain decimals (1500)")
+	fs.BoolVar(&opts.scientificStrict, "normalize-scientific-strict", false, "with -normalize-scientific, fail on numbers whose exponent is too large to expand instead of keeping them")
+	fs.BoolVar(&opts.negativeZero, "normalize-negative-zero", false, "drop the minus sign from negative zero numbers such as -0 and -0.0")
+	fs.Func("max-safe-int", "write integers whose magnitude exceeds N as strings (default 2^53-1, the exact range of a JavaScript number); int64 stringifies exactly the integers outside the signed 64-bit range", func(value string) error {
+		if value == maxSafeIntInt64 {
+			opts.maxSafeInt = value
+			return nil
+		}
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("want a non-negative integer or int64")
+		}
+		opts.maxSafeInt = strconv.FormatUint(n, 10)
+		return nil
+	})
+	fs.IntVar(&opts.maxNumberDigits, "max-number-digits", 0, "reject records containing a number with more than N mantissa digits; 0 disables the check")
+	fs.IntVar(&opts.startLine, "start-line", 0, "skip input records before this 1-based record number without parsing them")
+	fs.IntVar(&opts.endLine, "end-line", 0, "stop after this 1-based input record number; 0 reads to the end")
+	fs.IntVar(&opts.warnDupsOver, "warn-dups-over", 0, "log records that had more than N duplicate entries removed to stderr; 0 disables the warning")
+	fs.DurationVar(&opts.timeLines, "time-lines", 0, "log records whose processing takes longer than this duration (e.g. 50ms) to stderr")
+	fs.IntVar(&opts.limit, "limit", 0, "stop after reading N records; 0 reads all input")
+	fs.IntVar(&opts.readBuffer, "read-buffer", 0, "size in KB of the input and output buffers; 0 uses 4096")
+	fs.IntVar(&opts.flushEvery, "flush-every", 0, "flush output every N records (1 flushes after each record); 0 flushes only when the buffer fills")
+	fs.BoolVar(&opts.stripControl, "strip-control", false, "remove control characters below 0x20 from string values")
+	fs.BoolVar(&opts.keepControlSpace, "strip-control-keep-whitespace", false, "with -strip-control, keep tabs, newlines and carriage returns")
+	fs.BoolVar(&opts.replaceInvalid, "replace-invalid-utf8", false, "replace invalid UTF-8 sequences in string values with -replace-invalid-utf8-with")
+	fs.StringVar(&opts.invalidUTF8Repl, "replace-invalid-utf8-with", "\uFFFD", "replacement for each run of invalid UTF-8 bytes; empty removes them")
+	fs.BoolVar(&opts.rejectNonUTF8, "reject-non-utf8", false, "fail on input records that are not valid UTF-8 instead of passing the bytes through")
+	fs.StringVar(&opts.unicodeForm, "normalize-unicode-values", "", "normalize string values to Unicode NFC or NFKC")
+	fs.BoolVar(&opts.unescapeHTML, "unescape-html", false, "decode HTML entities such as &amp; in string values")
+	fs.BoolVar(&opts.collapseSpace, "collapse-whitespace", false, "replace runs of whitespace in string values with a single space")
+	fs.BoolVar(&opts.collapseSpaceTrim, "collapse-whitespace-trim", false, "with -collapse-whitespace or -collapse-whitespace-keys, also trim leading and trailing whitespace")
+	fs.BoolVar(&opts.collapseSpaceKeys, "collapse-whitespace-keys", false, "replace runs of whitespace in keys with a single space")
+	fs.IntVar(&opts.maxStringLen, "max-string-len", 0, "truncate string values longer than N bytes; 0 disables truncation")
+	fs.BoolVar(&opts.maxStringRunes, "max-string-len-runes", false, "with -max-string-len, count runes instead of bytes")
+	fs.StringVar(&opts.maxStringMarker, "max-string-len-marker", "", "with -max-string-len, end truncated strings with this marker (counted in the limit)")
+	fs.BoolVar(&opts.maxStringKeys, "max-string-len-keys", false, "with -max-string-len, truncate keys as well")
+	fs.Var(&opts.decodeEmbedded, "decode-embedded", "comma-separated keys (or *) whose string values holding a JSON object or array are deduplicated and re-encoded")
+	fs.BoolVar(&opts.changedOnly, "changed-only", false, "only write records that processing changed, compared with the compact form of the input")
+	fs.BoolVar(&opts.dropEmptyRecords, "drop-empty-records", false, "omit records that serialize to {} or []")
+	fs.StringVar(&opts.schemaFile, "jsonschema", "", "JSON Schema file (draft-07 unless $schema says otherwise) every output record must satisfy")
+	fs.StringVar(&opts.defaultsFile, "defaults", "", "JSON object file whose keys are added to records that lack them")
+	fs.StringVar(&opts.enrichFile, "enrich", "", "JSON object file merged into every output record; existing keys are kept unless -enrich-overwrite is set")
+	fs.BoolVar(&opts.enrichOverwrite, "enrich-overwrite", false, "with -enrich, replace the values of keys the record already has")
+	fs.Var(&opts.nullMissing, "null-missing", "comma-separated top-level keys added as null to records that lack them")
+	fs.StringVar(&opts.templateFile, "template", "", "JSON object file whose \"$.path\" string values are filled from each record to build the output")
+	fs.BoolVar(&opts.templateOmitMissing, "template-omit-missing", false, "omit -template fields whose path is missing instead of emitting null")
+}
+
 func main() {
+	opts := &options{}
 	cpuProfile := flag.String("cpuprofile", "", "write CPU profile to file")
+	registerFlags(flag.CommandLine, opts)
 	flag.Parse()
 
+	if err := opts.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+	if err := opts.load(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
 	if *cpuProfile != "" {
 		f, err := os.Create(*cpuProfile)
 		if err != nil {
@@ -520,46 +1437,198 @@ func main() {
 		}()
 	}
 
-	reader := bufio.NewReaderSize(os.Stdin, 4*1024*1024)
-	writer := bufio.NewWriterSize(os.Stdout, 4*1024*1024)
-	defer writer.Flush()
-	buf := bytes.NewBuffer(make([]byte, 0, 64*1024))
+	out, err := openOutput(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
 
-	for {
-		line, err := reader.ReadBytes('\n')
-		if err != nil && err != io.EOF {
-			fmt.Fprintf(os.Stderr, "stdin read error: %v\n", err)
-			return
+	if err := run(os.Stdin, out, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if closer, ok := out.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "output close error: %v\n", err)
+			os.Exit(1)
 		}
+	}
+}
 
-		if len(line) == 0 && err == io.EOF {
-			return
+// openOutput returns the destination selected by the output options.
+func openOutput(opts *options) (io.Writer, error) {
+	switch {
+	case opts.outputURL != "":
+		return newNetSink(opts.outputURL, opts.recordDelim())
+	case opts.batchLines > 0:
+		return newBatchWriter(opts.outPattern, opts.batchLines, opts.emitBOM), nil
+	case opts.postCmd != "":
+		return startPostCmd(opts.postCmd, os.Stdout)
+	default:
+		return os.Stdout, nil
+	}
+}
+
+// flushOutput pushes buffered records through to the output, including any
+// buffering the output does itself.
+func flushOutput(writer *bufio.Writer, out io.Writer) error {
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if f, ok := out.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// recordSink is implemented by outputs that act on record boundaries. run
+// flushes its buffer before calling EndRecord.
+type recordSink interface {
+	EndRecord() error
+}
+
+// timeNow and logOutput are replaced in tests.
+var (
+	timeNow             = time.Now
+	logOutput io.Writer = os.Stderr
+)
+
+// run deduplicates every line read from in and writes the results to out.
+func run(in io.Reader, out io.Writer, opts *options) (err error) {
+	delim := opts.recordDelim()
+	format := opts.inputFormat
+	// Detection looks at the raw first line, so it cannot see through a line
+	// prefix, skipped lines or another charset; those inputs stay NDJSON.
+	if format == inputFormatAuto && (opts.linePrefix != nil || opts.startLine > 1 || opts.inputCharset == charsetLatin1) {
+		format = inputFormatNDJSON
+	}
+	if format == inputFormatAuto && !opts.inputJSONArray {
+		detected, replay, err := detectInputFormat(in, delim, opts.bufferSize())
+		if err != nil {
+			return fmt.Errorf("stdin read error: %w", err)
 		}
+		format, in = detected, replay
+	}
+	var scanner recordSource
+	switch {
+	case opts.inputJSONArray:
+		scanner = newArrayScanner(in, opts.maxRecordSize, true)
+	case format == inputFormatJSON:
+		scanner = newArrayScanner(in, opts.maxRecordSize, false)
+	default:
+		scanner = newRecordScanner(in, delim, opts.maxRecordSize, opts.bufferSize())
+	}
+	writer := bufio.NewWriterSize(out, opts.bufferSize())
+	buf := bytes.NewBuffer(make([]byte, 0, 64*1024))
+	ctx := &dedupContext{opts: opts}
+	if opts.trace {
+		ctx.trace = os.Stderr
+	}
+	if opts.collisionAudit != "" {
+		ctx.audit = collisionAudit{}
+	}
+	var report runReport
+	if opts.dedupReport != "" {
+		defer func() {
+			if err != nil {
+				report.Errors++
+			}
+			if reportErr := report.writeReport(opts.dedupReport); reportErr != nil && err == nil {
+				err = fmt.Errorf("dedup report error: %w", reportErr)
+			}
+		}()
+	}
+	sink, _ := out.(recordSink)
+	written := 0
+	// Outputs that split records across files write a BOM per file instead.
+	if opts.emitBOM && sink == nil {
+		_, _ = writer.WriteString(utf8BOM)
+	}
 
-		hadNewline := false
-		n := len(line)
-		if n > 0 && line[n-1] == '\n' {
-			hadNewline = true
-			n--
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		if lineNum < opts.startLine {
+			continue
 		}
-		if n > 0 && line[n-1] == '\r' {
-			n--
+		if opts.endLine > 0 && lineNum > opts.endLine {
+			break
 		}
-		line = line[:n]
+		line := scanner.Record()
+		hadNewline := scanner.Terminated()
 
-		procErr := processLine(line, buf)
+		var start time.Time
+		if opts.timeLines > 0 {
+			start = timeNow()
+		}
+		procErr := processLine(line, buf, ctx)
+		if opts.timeLines > 0 {
+			if elapsed := timeNow().Sub(start); elapsed > opts.timeLines {
+				fmt.Fprintf(logOutput, "slow record %d (%d bytes): %s\n", lineNum, len(line), elapsed)
+			}
+		}
 		if procErr != nil {
-			fmt.Fprintf(os.Stderr, "line processing error: %v\n", procErr)
-			os.Exit(1)
+			return fmt.Errorf("line processing error: %w", procErr)
+		}
+		report.add(ctx.removed)
+		if opts.warnDupsOver > 0 && ctx.removed > opts.warnDupsOver {
+			fmt.Fprintf(logOutput, "record %d: %d duplicate entries removed\n", lineNum, ctx.removed)
+		}
+		if ctx.skipRecord {
+			report.RecordsDropped++
 		}
 
-		_, _ = writer.Write(buf.Bytes())
-		if hadNewline {
-			_, _ = writer.WriteString("\n")
+		if !opts.countOnly && !ctx.skipRecord {
+			switch {
+			case !opts.wrapArray:
+			case written == 0:
+				_ = writer.WriteByte('[')
+			default:
+				_ = writer.WriteByte(',')
+			}
+			_, _ = writer.Write(buf.Bytes())
+			if opts.terminateRecord(hadNewline) {
+				_ = writer.WriteByte(delim)
+			}
+			if sink != nil {
+				if err := writer.Flush(); err != nil {
+					return err
+				}
+				if err := sink.EndRecord(); err != nil {
+					return err
+				}
+			}
+			written++
+			if opts.flushEvery > 0 && written%opts.flushEvery == 0 {
+				if err := flushOutput(writer, out); err != nil {
+					return err
+				}
+			}
+		}
+		if opts.limit > 0 && report.Records >= opts.limit {
+			break
 		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("stdin read error: %w", err)
+	}
 
-		if err == io.EOF {
-			return
+	if opts.countOnly {
+		if err := report.runStats.writeJSON(writer); err != nil {
+			return err
+		}
+	}
+	if opts.wrapArray {
+		if written == 0 {
+			_ = writer.WriteByte('[')
+		}
+		_ = writer.WriteByte(']')
+		_ = writer.WriteByte(delim)
+	}
+	if ctx.audit != nil {
+		if err := ctx.audit.write(opts.collisionAudit); err != nil {
+			return fmt.Errorf("collision audit error: %w", err)
 		}
 	}
+	return writer.Flush()
 }
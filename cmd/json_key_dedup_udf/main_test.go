@@ -2,32 +2,940 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestProcessLineErrorsOnMalformedJSON(t *testing.T) {
 	var buf bytes.Buffer
-	err := processLine([]byte("{\"a\":"), &buf)
+	err := processLine([]byte("{\"a\":"), &buf, &dedupContext{opts: &options{}})
 	if err == nil {
 		t.Fatal("expected error for malformed JSON, got nil")
 	}
 }
 
 func TestShouldStringifyNumber(t *testing.T) {
-	tests := map[string]bool{
-		"0":                    false,
-		"42":                   false,
-		"9223372036854775807":  false,
-		"9223372036854775808":  true,
-		"-9223372036854775808": false,
-		"-9223372036854775809": true,
-		"1.25":                 false,
-		"1e6":                  false,
+	tests := []struct {
+		num, maxSafeInt string
+		want            bool
+	}{
+		{"0", defaultMaxSafeInt, false},
+		{"42", defaultMaxSafeInt, false},
+		{"9007199254740991", defaultMaxSafeInt, false},
+		{"9007199254740992", defaultMaxSafeInt, true},
+		{"-9007199254740991", defaultMaxSafeInt, false},
+		{"-9007199254740992", defaultMaxSafeInt, true},
+		{"0009007199254740991", defaultMaxSafeInt, false},
+		{"10000000000000000", defaultMaxSafeInt, true},
+		{"9007199254740992.0", defaultMaxSafeInt, false},
+		{"1e300", defaultMaxSafeInt, false},
+		{"9223372036854775807", "9223372036854775807", false},
+		{"9223372036854775808", "9223372036854775807", true},
+		{"-0", "1", false},
+		{"2", "1", true},
+		{"0", maxSafeIntInt64, false},
+		{"42", maxSafeIntInt64, false},
+		{"9223372036854775807", maxSafeIntInt64, false},
+		{"9223372036854775808", maxSafeIntInt64, true},
+		{"-9223372036854775808", maxSafeIntInt64, false},
+		{"-9223372036854775809", maxSafeIntInt64, true},
+		{"1.25", maxSafeIntInt64, false},
+		{"1e6", maxSafeIntInt64, false},
 	}
 
+	for _, tt := range tests {
+		if got := shouldStringifyNumber(tt.num, tt.maxSafeInt); got != tt.want {
+			t.Fatalf("shouldStringifyNumber(%q, %s) = %v, want %v", tt.num, tt.maxSafeInt, got, tt.want)
+		}
+	}
+}
+
+func TestMaxSafeInt(t *testing.T) {
+	input := `{"a":9007199254740991,"b":9007199254740992,"c":-9007199254740992,"d":9223372036854775807,"e":-9223372036854775808}`
+	tests := []struct {
+		maxSafeInt string
+		want       string
+	}{
+		{"", `{"a":9007199254740991,"b":"9007199254740992","c":"-9007199254740992","d":"9223372036854775807","e":"-9223372036854775808"}`},
+		{"9223372036854775807", `{"a":9007199254740991,"b":9007199254740992,"c":-9007199254740992,"d":9223372036854775807,"e":"-9223372036854775808"}`},
+		{"9223372036854775808", `{"a":9007199254740991,"b":9007199254740992,"c":-9007199254740992,"d":9223372036854775807,"e":-9223372036854775808}`},
+		{maxSafeIntInt64, `{"a":9007199254740991,"b":9007199254740992,"c":-9007199254740992,"d":9223372036854775807,"e":-9223372036854775808}`},
+		{"1000", `{"a":"9007199254740991","b":"9007199254740992","c":"-9007199254740992","d":"9223372036854775807","e":"-9223372036854775808"}`},
+	}
+	for _, tt := range tests {
+		got, err := dedupLine(&options{maxSafeInt: tt.maxSafeInt}, input)
+		if err != nil {
+			t.Fatalf("max %q: unexpected error: %v", tt.maxSafeInt, err)
+		}
+		if got != tt.want {
+			t.Fatalf("max %q: %s = %s, want %s", tt.maxSafeInt, input, got, tt.want)
+		}
+	}
+}
+
+func TestNumberTokensPreserved(t *testing.T) {
+	tokens := []string{
+		"1.00000000000000001",
+		"1e400",
+		"0.1e-400",
+		"-0",
+		"-0.0",
+		"1E+2",
+		"1.50",
+		"12345678901234567890.5",
+		"9007199254740991",
+	}
+	for _, num := range tokens {
+		input := `{"n":null,"n":` + num + `,"l":[` + num + `]}`
+		want := `{"n":` + num + `,"l":[` + num + `]}`
+		if got, err := dedupLine(&options{}, input); err != nil || got != want {
+			t.Fatalf("%s: got %s, %v; want %s", num, got, err, want)
+		}
+
+		var out bytes.Buffer
+		if err := run(strings.NewReader("["+input+"]"), &out, &options{inputJSONArray: true}); err != nil || out.String() != want+"\n" {
+			t.Fatalf("%s via -input-json-array: got %q, %v; want %q", num, out.String(), err, want+"\n")
+		}
+	}
+}
+
+func dedupLine(opts *options, input string) (string, error) {
+	var buf bytes.Buffer
+	if err := processLine([]byte(input), &buf, &dedupContext{opts: opts}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func TestScalarObjectConflictPolicies(t *testing.T) {
+	tests := []struct {
+		policy, tieBreak string
+		input            string
+		want             string
+	}{
+		{conflictDefault, "", `{"a":{"x":1},"a":5}`, `{"a":{"x":1}}`},
+		{conflictDefault, "", `{"a":5,"a":{"x":1}}`, `{"a":5}`},
+		{conflictKeepObject, "", `{"a":5,"a":{"x":1}}`, `{"a":{"x":1}}`},
+		{conflictKeepObject, "", `{"a":"","a":[1]}`, `{"a":[1]}`},
+		{conflictKeepObject, tieBreakLast, `{"a":{"x":1},"a":5,"a":[2]}`, `{"a":[2]}`},
+		{conflictKeepScalar, "", `{"a":{"x":1},"a":5}`, `{"a":5}`},
+		{conflictKeepScalar, "", `{"a":[1],"a":null,"a":""}`, `{"a":""}`},
+		{conflictKeepScalar, "", `{"a":1,"a":2}`, `{"a":1}`},
+		{conflictKeepScalar, tieBreakLast, `{"a":5,"a":{"x":1},"a":6,"a":""}`, `{"a":6}`},
+	}
+
+	for _, tt := range tests {
+		got, err := dedupLine(&options{scalarObjectConflict: tt.policy, tieBreak: tt.tieBreak}, tt.input)
+		if err != nil {
+			t.Fatalf("policy %q/%q on %s: unexpected error: %v", tt.policy, tt.tieBreak, tt.input, err)
+		}
+		if got != tt.want {
+			t.Fatalf("policy %q/%q on %s = %s, want %s", tt.policy, tt.tieBreak, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestScalarObjectConflictErrorPolicy(t *testing.T) {
+	opts := &options{scalarObjectConflict: conflictError}
+	if _, err := dedupLine(opts, `{"a":{"x":1},"a":5}`); err == nil {
+		t.Fatal("expected error for mixed object/scalar duplicate, got nil")
+	}
+	if got, err := dedupLine(opts, `{"a":1,"a":2}`); err != nil || got != `{"a":1}` {
+		t.Fatalf("scalar-only duplicate = %q, %v; want {\"a\":1}, nil", got, err)
+	}
+}
+
+func TestResolvePolicySize(t *testing.T) {
+	tests := []struct {
+		policy string
+		input  string
+		want   string
+	}{
+		{policyLargest, `{"a":"abc","a":"abcdef","a":"ab"}`, `{"a":"abcdef"}`},
+		{policySmallest, `{"a":"abc","a":"abcdef","a":"ab"}`, `{"a":"ab"}`},
+		{policySmallest, `{"a":"","a":"xyz","a":null,"a":"wxyz"}`, `{"a":"xyz"}`},
+		{policyLargest, `{"a":[1,2],"a":[1,2,3],"a":[1]}`, `{"a":[1,2,3]}`},
+		{policyLargest, `{"a":"ab","a":"cd"}`, `{"a":"ab"}`},
+		{policySmallest, `{"a":null,"a":""}`, `{"a":""}`},
+		{policyLargest, `{"o":{"a":"x","a":"xy"},"b":1}`, `{"o":{"a":"xy"},"b":1}`},
+	}
+	for _, tt := range tests {
+		got, err := dedupLine(&options{resolvePolicy: tt.policy}, tt.input)
+		if err != nil {
+			t.Fatalf("policy %q on %s: unexpected error: %v", tt.policy, tt.input, err)
+		}
+		if got != tt.want {
+			t.Fatalf("policy %q on %s = %s, want %s", tt.policy, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestResolvePolicyNumeric(t *testing.T) {
+	tests := []struct {
+		policy string
+		input  string
+		want   string
+	}{
+		{policyNumMax, `{"n":3,"n":10,"n":-2}`, `{"n":10}`},
+		{policyNumMin, `{"n":3,"n":10,"n":-2}`, `{"n":-2}`},
+		{policyNumMax, `{"n":9223372036854775806,"n":9223372036854775807,"n":9223372036854775805}`, `{"n":9223372036854775807}`},
+		{policyNumMin, `{"n":-9223372036854775807,"n":-9223372036854775808}`, `{"n":-9223372036854775808}`},
+		{policyNumMax, `{"n":1.5,"n":1.25e1,"n":12}`, `{"n":1.25e1}`},
+		{policyNumMax, `{"n":null,"n":4,"n":7}`, `{"n":7}`},
+		{policyNumMax, `{"n":2,"n":2.0}`, `{"n":2}`},
+		{policyNumMax, `{"n":1,"n":"5"}`, `{"n":1}`},
+	}
+	for _, tt := range tests {
+		// Raise -max-safe-int so the int64 boundary cases stay numbers.
+		got, err := dedupLine(&options{resolvePolicy: tt.policy, maxSafeInt: "18446744073709551615"}, tt.input)
+		if err != nil {
+			t.Fatalf("policy %q on %s: unexpected error: %v", tt.policy, tt.input, err)
+		}
+		if got != tt.want {
+			t.Fatalf("policy %q on %s = %s, want %s", tt.policy, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeSlash(t *testing.T) {
+	tests := []struct {
+		escape bool
+		input  string
+		want   string
+	}{
+		{false, `{"a":"a/b"}`, `{"a":"a/b"}`},
+		{true, `{"a":"a/b"}`, `{"a":"a\/b"}`},
+		{true, `{"a/b":"http://x/y","a/b":"z"}`, `{"a\/b":"http:\/\/x\/y"}`},
+		{true, `{"a":"a\/b"}`, `{"a":"a\/b"}`},
+		{true, `{"a":1}`, `{"a":1}`},
+	}
+	for _, tt := range tests {
+		got, err := dedupLine(&options{escapeSlash: tt.escape}, tt.input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.input, err)
+		}
+		if got != tt.want {
+			t.Fatalf("escape=%v %s = %s, want %s", tt.escape, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestDedupMaxDepth(t *testing.T) {
+	input := `{"a":1,"a":2,"n":{"b":null,"b":3,"m":{"c":4,"c":5}},"l":[{"d":6,"d":7}]}`
+	tests := map[int]string{
+		0: `{"a":1,"n":{"b":3,"m":{"c":4}},"l":[{"d":6}]}`,
+		1: `{"a":1,"n":{"b":null,"b":3,"m":{"c":4,"c":5}},"l":[{"d":6,"d":7}]}`,
+		2: `{"a":1,"n":{"b":3,"m":{"c":4,"c":5}},"l":[{"d":6}]}`,
+		3: `{"a":1,"n":{"b":3,"m":{"c":4}},"l":[{"d":6}]}`,
+	}
+	for depth, want := range tests {
+		got, err := dedupLine(&options{dedupMaxDepth: depth}, input)
+		if err != nil {
+			t.Fatalf("depth %d: unexpected error: %v", depth, err)
+		}
+		if got != want {
+			t.Fatalf("depth %d = %s, want %s", depth, got, want)
+		}
+	}
+}
+
+func TestResolvePolicyTieBreak(t *testing.T) {
+	tests := []struct {
+		policy, tieBreak string
+		input            string
+		want             string
+	}{
+		{policyLargest, "", `{"a":"ab","a":"c","a":"de"}`, `{"a":"ab"}`},
+		{policyLargest, tieBreakLast, `{"a":"ab","a":"c","a":"de"}`, `{"a":"de"}`},
+		{policySmallest, tieBreakFirst, `{"a":"x","a":"yz","a":"w"}`, `{"a":"x"}`},
+		{policySmallest, tieBreakLast, `{"a":"x","a":"yz","a":"w"}`, `{"a":"w"}`},
+		{policyNumMax, tieBreakFirst, `{"n":2,"n":2.0,"n":1}`, `{"n":2}`},
+		{policyNumMax, tieBreakLast, `{"n":2,"n":2.0,"n":1}`, `{"n":2.0}`},
+		{policyNumMin, tieBreakLast, `{"n":1e0,"n":3,"n":1}`, `{"n":1}`},
+	}
+	for _, tt := range tests {
+		got, err := dedupLine(&options{resolvePolicy: tt.policy, tieBreak: tt.tieBreak}, tt.input)
+		if err != nil {
+			t.Fatalf("%s/%s on %s: unexpected error: %v", tt.policy, tt.tieBreak, tt.input, err)
+		}
+		if got != tt.want {
+			t.Fatalf("%s/%s on %s = %s, want %s", tt.policy, tt.tieBreak, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestRequireTopObject(t *testing.T) {
+	opts := &options{requireTopObject: true}
+	tests := map[string]string{
+		`[{"a":1}]`: "array",
+		`"text"`:    "string",
+		`42`:        "number",
+		`true`:      "bool",
+		`null`:      "null",
+	}
+	for input, typeName := range tests {
+		_, err := dedupLine(opts, input)
+		if err == nil {
+			t.Fatalf("%s: expected error, got nil", input)
+		}
+		if !strings.Contains(err.Error(), typeName) {
+			t.Fatalf("%s: error %q does not mention %q", input, err, typeName)
+		}
+	}
+
+	if got, err := dedupLine(opts, `{"a":1,"a":2}`); err != nil || got != `{"a":1}` {
+		t.Fatalf("object record = %q, %v; want {\"a\":1}, nil", got, err)
+	}
+	if got, err := dedupLine(&options{}, `[1]`); err != nil || got != `[1]` {
+		t.Fatalf("array without flag = %q, %v; want [1], nil", got, err)
+	}
+}
+
+func TestExpansionMergesLiteralObjects(t *testing.T) {
+	tests := map[string]string{
+		`{"a":{"x":1},"a.y":2}`:                    `{"a":{"x":1,"y":2}}`,
+		`{"a.y":2,"a":{"x":1}}`:                    `{"a":{"y":2,"x":1}}`,
+		`{"a.y":2,"a":{"y":3,"x":1},"a.z":4}`:      `{"a":{"y":2,"x":1,"z":4}}`,
+		`{"a.y":"","a":{"y":3}}`:                   `{"a":{"y":3}}`,
+		`{"a.b.c":1,"a":{"b":{"d":2},"e":3}}`:      `{"a":{"b":{"c":1,"d":2},"e":3}}`,
+		`{"a.y":2,"a":{"x":1},"a":{"w":0},"k":{}}`: `{"a":{"y":2,"x":1,"w":0},"k":{}}`,
+		`{"a.y":2,"a":5}`:                          `{"a":{"y":2}}`,
+	}
+	for input, want := range tests {
+		got, err := dedupLine(&options{}, input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", input, err)
+		}
+		if got != want {
+			t.Fatalf("%s = %s, want %s", input, got, want)
+		}
+	}
+}
+
+func TestExpandKeysAllowlist(t *testing.T) {
+	opts := &options{expandKeys: stringList{"user."}}
+	tests := map[string]string{
+		`{"user.name":"x","version.major":1}`:                     `{"user":{"name":"x"},"version.major":1}`,
+		`{"user":{"name":""},"user.name":"x","a.b":"","a.b":"y"}`: `{"user":{"name":"x"},"a.b":"y"}`,
+		`{"nested":{"user.id":1,"host.ip":"1.2.3.4"}}`:            `{"nested":{"user":{"id":1},"host.ip":"1.2.3.4"}}`,
+	}
 	for input, want := range tests {
-		if got := shouldStringifyNumber(input); got != want {
-			t.Fatalf("shouldStringifyNumber(%q) = %v, want %v", input, got, want)
+		got, err := dedupLine(opts, input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", input, err)
+		}
+		if got != want {
+			t.Fatalf("%s = %s, want %s", input, got, want)
+		}
+	}
+}
+
+func TestRunReadsNULDelimitedRecords(t *testing.T) {
+	input := "{\"a\":1,\"a\":2}\x00{\"b\":\"line\\nbreak\",\"b\":\"\"}\x00{\"c\":3}"
+	var out bytes.Buffer
+	if err := run(strings.NewReader(input), &out, &options{inputDelim: "\x00"}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	want := "{\"a\":1}\x00{\"b\":\"line\\nbreak\"}\x00{\"c\":3}"
+	if got := out.String(); got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+}
+
+func TestNormalizeUnderscores(t *testing.T) {
+	opts := &options{normalizeUnderscores: true}
+	tests := map[string]string{
+		`{"_x":1,"x":2}`:                `{"_x":1}`,
+		`{"x":"","__x__":"v","y":1}`:    `{"__x__":"v","y":1}`,
+		`{"a":{"id_":null,"id":3}}`:     `{"a":{"id":3}}`,
+		`{"_x":1,"x":2,"x_y":3,"xy":4}`: `{"_x":1,"x_y":3,"xy":4}`,
+	}
+	for input, want := range tests {
+		got, err := dedupLine(opts, input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", input, err)
+		}
+		if got != want {
+			t.Fatalf("%s = %s, want %s", input, got, want)
+		}
+	}
+
+	if got, _ := dedupLine(&options{}, `{"_x":1,"x":2}`); got != `{"_x":1,"x":2}` {
+		t.Fatalf("without flag = %s, want both keys kept", got)
+	}
+}
+
+func TestSuffixDuplicates(t *testing.T) {
+	opts := &options{suffixDuplicates: true}
+	tests := map[string]string{
+		`{"a":1,"a":2,"a":3}`:         `{"a":1,"a_2":2,"a_3":3}`,
+		`{"a":null,"b":1,"a":""}`:     `{"a":null,"b":1,"a_2":""}`,
+		`{"a":1,"a_2":"x","a":2}`:     `{"a":1,"a_2":"x","a_3":2}`,
+		`{"o":{"k":1,"k":2},"o":[1]}`: `{"o":{"k":1,"k_2":2},"o_2":[1]}`,
+	}
+	for input, want := range tests {
+		got, err := dedupLine(opts, input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", input, err)
+		}
+		if got != want {
+			t.Fatalf("%s = %s, want %s", input, got, want)
+		}
+	}
+}
+
+func TestNoDedupKeys(t *testing.T) {
+	tests := []struct {
+		opts  options
+		input string
+		want  string
+	}{
+		{options{noDedupKeys: stringList{"cookie"}}, `{"cookie":"a","id":1,"cookie":"b","id":2,"cookie":""}`, `{"cookie":"a","id":1,"cookie":"b","cookie":""}`},
+		{options{noDedupKeys: stringList{"cookie"}}, `{"h":{"cookie":1,"cookie":1,"x":null,"x":2}}`, `{"h":{"cookie":1,"cookie":1,"x":2}}`},
+		{options{noDedupKeys: stringList{"cookie"}, suffixDuplicates: true}, `{"cookie":1,"cookie":2,"id":1,"id":2}`, `{"cookie":1,"cookie":2,"id":1,"id_2":2}`},
+		{options{noDedupKeys: stringList{"cookie"}, resolvePolicy: policyLargest}, `{"cookie":"a","cookie":"bb","id":"a","id":"bb"}`, `{"cookie":"a","cookie":"bb","id":"bb"}`},
+	}
+	for _, tt := range tests {
+		got, err := dedupLine(&tt.opts, tt.input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.input, err)
+		}
+		if got != tt.want {
+			t.Fatalf("%s = %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestDropIdenticalPairs(t *testing.T) {
+	tests := []struct {
+		opts  options
+		input string
+		want  string
+	}{
+		{options{dropIdenticalPairs: true, suffixDuplicates: true}, `{"a":1,"a":1,"a":2}`, `{"a":1,"a_2":2}`},
+		{options{suffixDuplicates: true}, `{"a":1,"a":1,"a":2}`, `{"a":1,"a_2":1,"a_3":2}`},
+		{options{dropIdenticalPairs: true, suffixDuplicates: true}, `{"a":{"x":[1]},"b":1,"a":{"x":[1]}}`, `{"a":{"x":[1]},"b":1}`},
+		{options{dropIdenticalPairs: true, suffixDuplicates: true}, `{"a":{"x":1,"y":2},"a":{"y":2,"x":1}}`, `{"a":{"x":1,"y":2},"a_2":{"y":2,"x":1}}`},
+		{options{dropIdenticalPairs: true, spillDuplicates: true}, `{"a":1,"a":1,"a":2,"a":2}`, `{"a":1,"a_dups":[2]}`},
+		{options{dropIdenticalPairs: true}, `{"a":1,"b":1}`, `{"a":1,"b":1}`},
+		{options{dropIdenticalPairs: true, suffixDuplicates: true}, `{"a":"\u00e9","a":"é"}`, `{"a":"é"}`},
+		{options{dropIdenticalPairs: true, suffixDuplicates: true}, `{"a":"e\u0301","a":"\u00e9"}`, "{\"a\":\"e\u0301\",\"a_2\":\"\u00e9\"}"},
+		{options{dropIdenticalPairs: true, suffixDuplicates: true, unicodeEqual: true}, `{"a":"e\u0301","a":"\u00e9"}`, "{\"a\":\"e\u0301\"}"},
+		{options{dropIdenticalPairs: true, suffixDuplicates: true, unicodeEqual: true}, `{"e\u0301":1,"\u00e9":1}`, "{\"e\u0301\":1}"},
+	}
+	for _, tt := range tests {
+		got, err := dedupLine(&tt.opts, tt.input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.input, err)
+		}
+		if got != tt.want {
+			t.Fatalf("%s = %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestSpillDuplicates(t *testing.T) {
+	tests := map[string]string{
+		`{"a":1,"a":2,"a":3}`:                        `{"a":1,"a_dups":[2,3]}`,
+		`{"a":null,"b":1,"a":"x","a":{"y":1}}`:       `{"b":1,"a":"x","a_dups":[null,{"y":1}]}`,
+		`{"a":1,"a_dups":0,"a":2}`:                   `{"a":1,"a_dups_2":[2],"a_dups":0}`,
+		`{"a":1,"b":2}`:                              `{"a":1,"b":2}`,
+		`{"a":1,"b.c":0,"a":2,"x":1,"a":3}`:          `{"a":1,"a_dups":[2,3],"b":{"c":0},"x":1}`,
+		`{"a.p":1,"y":0,"a.p":2,"a.q":3,"a.p":4}`:    `{"a":{"p":1,"p_dups":[2,4],"q":3},"y":0}`,
+		`{"n":{"k":"","k":"v"},"l":[{"k":1,"k":1}]}`: `{"n":{"k":"v","k_dups":[""]},"l":[{"k":1,"k_dups":[1]}]}`,
+	}
+	for input, want := range tests {
+		got, err := dedupLine(&options{spillDuplicates: true}, input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", input, err)
+		}
+		if got != want {
+			t.Fatalf("%s = %s, want %s", input, got, want)
+		}
+	}
+}
+
+func TestArrayDedupBy(t *testing.T) {
+	tests := []struct {
+		path  string
+		input string
+		want  string
+	}{
+		{"id", `{"items":[{"id":1,"v":"a"},{"id":2},{"id":1,"v":"b"}]}`, `{"items":[{"id":1,"v":"a"},{"id":2}]}`},
+		{"id", `[{"id":"1"},{"id":1},{"v":1},{"v":1},3,3]`, `[{"id":"1"},{"id":1},{"v":1},{"v":1},3,3]`},
+		{"id", `[{"id":{"a":1,"a":2}},{"id":{"a":1}}]`, `[{"id":{"a":1}}]`},
+		{"meta.id", `{"l":[{"meta":{"id":7},"n":1},{"meta.id":7,"n":2}]}`, `{"l":[{"meta":{"id":7},"n":1}]}`},
+		{"id", `{"l":[{"id":1,"sub":[{"id":1},{"id":1}]},{"id":1}]}`, `{"l":[{"id":1,"sub":[{"id":1}]}]}`},
+	}
+	for _, tt := range tests {
+		got, err := dedupLine(&options{arrayDedupBy: tt.path}, tt.input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.input, err)
+		}
+		if got != tt.want {
+			t.Fatalf("by %s: %s = %s, want %s", tt.path, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestPreserveAmbiguousExpansion(t *testing.T) {
+	tests := []struct {
+		preserve bool
+		input    string
+		want     string
+	}{
+		{false, `{"a":1,"a.b":2}`, `{"a":1}`},
+		{false, `{"a.b":2,"a":1}`, `{"a":{"b":2}}`},
+		{true, `{"a":1,"a.b":2}`, `{"a":1,"a_expanded":{"b":2}}`},
+		{true, `{"a.b":2,"a":1}`, `{"a_expanded":{"b":2},"a":1}`},
+		{true, `{"a.b":2,"a":1,"a.c":3}`, `{"a_expanded":{"b":2,"c":3},"a":1}`},
+		{true, `{"a.b":1,"a.b.c":2}`, `{"a":{"b":1,"b_expanded":{"c":2}}}`},
+		{true, `{"a":{"x":1},"a.y":2}`, `{"a":{"x":1,"y":2}}`},
+	}
+	for _, tt := range tests {
+		got, err := dedupLine(&options{preserveAmbiguous: tt.preserve}, tt.input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.input, err)
+		}
+		if got != tt.want {
+			t.Fatalf("preserve=%v %s = %s, want %s", tt.preserve, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestRunDropEmptyRecords(t *testing.T) {
+	input := "{\"a\":null,\"a\":null}\n{}\n[]\n{\"b\":{\"c\":1}}\n{\"b\":{}}\n"
+	opts := &options{dropEmptyRecords: true, selectPaths: stringList{"b.c"}}
+
+	var out bytes.Buffer
+	if err := run(strings.NewReader(input), &out, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got, want := out.String(), "{\"b\":{\"c\":1}}\n"; got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+
+	out.Reset()
+	if err := run(strings.NewReader("{}\n[]\n{\"a\":null}\n"), &out, &options{dropEmptyRecords: true}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got, want := out.String(), "{\"a\":null}\n"; got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+}
+
+func TestRunWrapArray(t *testing.T) {
+	tests := map[string]string{
+		"{\"a\":1,\"a\":2}\n{\"b\":null,\"b\":3}\n[1]\n": "[{\"a\":1},{\"b\":3},[1]]\n",
+		"{\"a\":1}": "[{\"a\":1}]\n",
+		"":          "[]\n",
+	}
+	for input, want := range tests {
+		var out bytes.Buffer
+		if err := run(strings.NewReader(input), &out, &options{wrapArray: true}); err != nil {
+			t.Fatalf("run(%q): %v", input, err)
+		}
+		if got := out.String(); got != want {
+			t.Fatalf("run(%q) = %q, want %q", input, got, want)
+		}
+		if !json.Valid(out.Bytes()) {
+			t.Fatalf("run(%q) output is not valid JSON: %q", input, out.String())
+		}
+	}
+
+	var out bytes.Buffer
+	if err := run(strings.NewReader("{\"a\":1}\x00{\"b\":2}\x00"), &out, &options{wrapArray: true, inputDelim: "\x00"}); err != nil {
+		t.Fatalf("NUL delimiter: run: %v", err)
+	}
+	if got, want := out.String(), "[{\"a\":1},{\"b\":2}]\x00"; got != want {
+		t.Fatalf("NUL delimiter: output = %q, want %q", got, want)
+	}
+}
+
+func TestRunBlankLine(t *testing.T) {
+	input := "{\"a\":1}\n\n{\"b\":2,\"b\":3}\n  \t\n"
+	tests := map[string]string{
+		blankLineSkip:        "{\"a\":1}\n{\"b\":2}\n",
+		blankLineEmptyObject: "{\"a\":1}\n{}\n{\"b\":2}\n{}\n",
+	}
+	for mode, want := range tests {
+		var out bytes.Buffer
+		if err := run(strings.NewReader(input), &out, &options{blankLine: mode}); err != nil {
+			t.Fatalf("%s: run: %v", mode, err)
+		}
+		if got := out.String(); got != want {
+			t.Fatalf("%s: output = %q, want %q", mode, got, want)
+		}
+	}
+
+	for _, mode := range []string{"", blankLineError} {
+		var out bytes.Buffer
+		if err := run(strings.NewReader(input), &out, &options{blankLine: mode}); err == nil {
+			t.Fatalf("%q: expected error for blank line, got nil", mode)
+		}
+	}
+}
+
+func TestRunTrailingNewline(t *testing.T) {
+	input := "{\"a\":1,\"a\":2}\n{\"b\":3}"
+	tests := map[string]string{
+		"":                    "{\"a\":1}\n{\"b\":3}",
+		trailingNewlineAuto:   "{\"a\":1}\n{\"b\":3}",
+		trailingNewlineAlways: "{\"a\":1}\n{\"b\":3}\n",
+		trailingNewlineNever:  "{\"a\":1}{\"b\":3}",
+	}
+	for mode, want := range tests {
+		var out bytes.Buffer
+		if err := run(strings.NewReader(input), &out, &options{trailingNewline: mode}); err != nil {
+			t.Fatalf("%q: run: %v", mode, err)
+		}
+		if got := out.String(); got != want {
+			t.Fatalf("%q: output = %q, want %q", mode, got, want)
+		}
+	}
+
+	var out bytes.Buffer
+	opts := &options{trailingNewline: trailingNewlineAlways, inputDelim: "\x00"}
+	if err := run(strings.NewReader("{\"a\":1}\x00{\"b\":2}"), &out, opts); err != nil {
+		t.Fatalf("NUL delimiter: run: %v", err)
+	}
+	if got, want := out.String(), "{\"a\":1}\x00{\"b\":2}\x00"; got != want {
+		t.Fatalf("NUL delimiter: output = %q, want %q", got, want)
+	}
+}
+
+func TestRunChangedOnly(t *testing.T) {
+	input := "{\"a\":1,\"b\":[1,2]}\n{\"a\":1,\"a\":2}\n{ \"a\" : 1 }\n{\"a.b\":1}\n{\"n\":{\"x\":\"\",\"x\":\"y\"}}\n"
+	tests := []struct {
+		opts options
+		want string
+	}{
+		{options{changedOnly: true}, "{\"a\":1}\n{\"a\":{\"b\":1}}\n{\"n\":{\"x\":\"y\"}}\n"},
+		{options{changedOnly: true, keyOrder: keyOrderSorted}, "{\"a\":1}\n{\"a\":{\"b\":1}}\n{\"n\":{\"x\":\"y\"}}\n"},
+		{options{changedOnly: true, keyPrefix: "p_", keyAffixDepth: 1}, "{\"p_a\":1,\"p_b\":[1,2]}\n{\"p_a\":1}\n{\"p_a\":1}\n{\"p_a\":{\"b\":1}}\n{\"p_n\":{\"x\":\"y\"}}\n"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if err := run(strings.NewReader(input), &out, &tt.opts); err != nil {
+			t.Fatalf("run: %v", err)
+		}
+		if got := out.String(); got != tt.want {
+			t.Fatalf("keyOrder=%q keyPrefix=%q: output = %q, want %q", tt.opts.keyOrder, tt.opts.keyPrefix, got, tt.want)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := run(strings.NewReader("{\"b\":1,\"a\":2}\n"), &out, &options{changedOnly: true, keyOrder: keyOrderSorted}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got, want := out.String(), "{\"a\":2,\"b\":1}\n"; got != want {
+		t.Fatalf("reordered record = %q, want %q", got, want)
+	}
+
+	// The baseline is the input text, not the converted tree: a big integer
+	// that is stringified counts as changed, and an already escaped slash
+	// does not.
+	out.Reset()
+	if err := run(strings.NewReader("{\"a\":9007199254740993}\n{\"a\":1}\n"), &out, &options{changedOnly: true}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got, want := out.String(), "{\"a\":\"9007199254740993\"}\n"; got != want {
+		t.Fatalf("big integer: output = %q, want %q", got, want)
+	}
+	out.Reset()
+	input = "{\"u\":\"a\\/b\"}\n{\"u\":\"a/b\"}\n{\"u\":\"ab\"}\n"
+	if err := run(strings.NewReader(input), &out, &options{changedOnly: true, escapeSlash: true}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got, want := out.String(), "{\"u\":\"a\\/b\"}\n"; got != want {
+		t.Fatalf("escape slash: output = %q, want %q", got, want)
+	}
+}
+
+func TestRunLimit(t *testing.T) {
+	input := "{\"a\":1,\"a\":2}\n{\"b\":1}\n{}\nnot json\n"
+	tests := []struct {
+		opts options
+		want string
+	}{
+		{options{limit: 2}, "{\"a\":1}\n{\"b\":1}\n"},
+		{options{limit: 3, dropEmptyRecords: true}, "{\"a\":1}\n{\"b\":1}\n"},
+		{options{limit: 1, countOnly: true}, "{\"records\":1,\"records_with_duplicates\":1,\"duplicates_removed\":1}\n"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if err := run(strings.NewReader(input), &out, &tt.opts); err != nil {
+			t.Fatalf("limit %d: run: %v", tt.opts.limit, err)
+		}
+		if got := out.String(); got != tt.want {
+			t.Fatalf("limit %d: output = %q, want %q", tt.opts.limit, got, tt.want)
+		}
+	}
+	if err := run(strings.NewReader(input), &bytes.Buffer{}, &options{limit: 4}); err == nil {
+		t.Fatal("limit 4: expected the malformed fourth record to fail, got nil")
+	}
+}
+
+func TestRunLineRange(t *testing.T) {
+	input := "not json\n{\"a\":1,\"a\":2}\n{\"b\":3}\n{\"c\":4,\"c\":5}\nnot json either\n"
+	tests := []struct {
+		start, end int
+		want       string
+	}{
+		{2, 4, "{\"a\":1}\n{\"b\":3}\n{\"c\":4}\n"},
+		{3, 3, "{\"b\":3}\n"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if err := run(strings.NewReader(input), &out, &options{startLine: tt.start, endLine: tt.end}); err != nil {
+			t.Fatalf("range %d-%d: run: %v", tt.start, tt.end, err)
+		}
+		if got := out.String(); got != tt.want {
+			t.Fatalf("range %d-%d: output = %q, want %q", tt.start, tt.end, got, tt.want)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := run(strings.NewReader("not json\n{\"a\":1}\n{\"b\":2}"), &out, &options{startLine: 2}); err != nil {
+		t.Fatalf("open range: run: %v", err)
+	}
+	if got, want := out.String(), "{\"a\":1}\n{\"b\":2}"; got != want {
+		t.Fatalf("open range: output = %q, want %q", got, want)
+	}
+}
+
+func TestRunTimeLines(t *testing.T) {
+	input := []string{`{"a":1}`, `{"a":1,"a":2,"bbbbbbbbbbbbbbbbbbbb":3}`, `{"b":2}`}
+
+	// The fake clock advances by the record length in milliseconds between
+	// the two readings taken around each processLine call.
+	var clock time.Time
+	calls := 0
+	timeNow = func() time.Time {
+		if calls%2 == 1 {
+			clock = clock.Add(time.Duration(len(input[calls/2])) * time.Millisecond)
+		}
+		calls++
+		return clock
+	}
+	var log bytes.Buffer
+	logOutput = &log
+	t.Cleanup(func() {
+		timeNow = time.Now
+		logOutput = os.Stderr
+	})
+
+	var out bytes.Buffer
+	if err := run(strings.NewReader(strings.Join(input, "\n")), &out, &options{timeLines: 20 * time.Millisecond}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got, want := log.String(), "slow record 2 (38 bytes): 38ms\n"; got != want {
+		t.Fatalf("log = %q, want %q", got, want)
+	}
+}
+
+func TestRunWarnDupsOver(t *testing.T) {
+	var log bytes.Buffer
+	logOutput = &log
+	t.Cleanup(func() { logOutput = os.Stderr })
+
+	input := "{\"a\":1,\"a\":2}\n{\"a\":1,\"a\":2,\"a\":3,\"b\":{\"c\":1,\"c\":2}}\n{\"a\":1,\"a\":2,\"a\":3}\n"
+	var out bytes.Buffer
+	if err := run(strings.NewReader(input), &out, &options{warnDupsOver: 2}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got, want := log.String(), "record 2: 3 duplicate entries removed\n"; got != want {
+		t.Fatalf("log = %q, want %q", got, want)
+	}
+}
+
+func TestRunEmitBOM(t *testing.T) {
+	var out bytes.Buffer
+	if err := run(strings.NewReader("{\"a\":1}\n{\"b\":2}\n"), &out, &options{emitBOM: true}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got, want := out.String(), "\xef\xbb\xbf{\"a\":1}\n{\"b\":2}\n"; got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+}
+
+// flushRecorder records each chunk the buffered writer flushes to it.
+type flushRecorder struct {
+	chunks []string
+}
+
+func (f *flushRecorder) Write(p []byte) (int, error) {
+	f.chunks = append(f.chunks, string(p))
+	return len(p), nil
+}
+
+func TestRunFlushEvery(t *testing.T) {
+	input := "{\"n\":1}\n{\"n\":2}\n{\"n\":3}\n{\"n\":4}\n{\"n\":5}\n"
+
+	out := &flushRecorder{}
+	if err := run(strings.NewReader(input), out, &options{flushEvery: 2}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	want := []string{"{\"n\":1}\n{\"n\":2}\n", "{\"n\":3}\n{\"n\":4}\n", "{\"n\":5}\n"}
+	if strings.Join(out.chunks, "|") != strings.Join(want, "|") {
+		t.Fatalf("flushed chunks = %q, want %q", out.chunks, want)
+	}
+
+	out = &flushRecorder{}
+	if err := run(strings.NewReader(input), out, &options{flushEvery: 1}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(out.chunks) != 5 {
+		t.Fatalf("flush-every 1 produced %d flushes, want 5: %q", len(out.chunks), out.chunks)
+	}
+
+	out = &flushRecorder{}
+	if err := run(strings.NewReader(input), out, &options{}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(out.chunks) != 1 {
+		t.Fatalf("default flushing produced %d flushes, want 1: %q", len(out.chunks), out.chunks)
+	}
+}
+
+func TestIgnoreEmptyHeuristic(t *testing.T) {
+	tests := []struct {
+		input       string
+		heuristic   string
+		noHeuristic string
+	}{
+		{`{"a":null,"a":"x"}`, `{"a":"x"}`, `{"a":null}`},
+		{`{"a":"","a":"","a":"y"}`, `{"a":"y"}`, `{"a":""}`},
+		{`{"a":"","a":null}`, `{"a":null}`, `{"a":""}`},
+		{`{"a":1,"a":2}`, `{"a":1}`, `{"a":1}`},
+	}
+	for _, tt := range tests {
+		got, err := dedupLine(&options{}, tt.input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.input, err)
+		}
+		if got != tt.heuristic {
+			t.Fatalf("with heuristic %s = %s, want %s", tt.input, got, tt.heuristic)
+		}
+
+		got, err = dedupLine(&options{ignoreEmptyHeuristic: true}, tt.input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.input, err)
+		}
+		if got != tt.noHeuristic {
+			t.Fatalf("without heuristic %s = %s, want %s", tt.input, got, tt.noHeuristic)
+		}
+	}
+}
+
+func TestEmptyValues(t *testing.T) {
+	opts := &options{emptyValues: stringList{"N/A", "-", "0"}}
+	tests := map[string]string{
+		`{"a":"N/A","a":"real"}`:      `{"a":"real"}`,
+		`{"a":"-","a":null,"a":"x"}`:  `{"a":"x"}`,
+		`{"a":0,"a":42}`:              `{"a":42}`,
+		`{"a":"0","a":"7"}`:           `{"a":"7"}`,
+		`{"a":0.0,"a":42}`:            `{"a":0.0}`,
+		`{"a":"N/A","a":"-"}`:         `{"a":"-"}`,
+		`{"a":"n/a","a":"real"}`:      `{"a":"n/a"}`,
+		`{"a":"N/A","a":{"b":"N/A"}}`: `{"a":{"b":"N/A"}}`,
+	}
+	for input, want := range tests {
+		got, err := dedupLine(opts, input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", input, err)
+		}
+		if got != want {
+			t.Fatalf("%s = %s, want %s", input, got, want)
+		}
+	}
+}
+
+func TestMaxNumberDigits(t *testing.T) {
+	opts := &options{maxNumberDigits: 5}
+	for _, input := range []string{`{"a":123456}`, `{"a":[1,{"b":-1234.56}]}`, `{"a":1.23456e2}`} {
+		if _, err := dedupLine(opts, input); err == nil || !strings.Contains(err.Error(), "more than 5 digits") {
+			t.Fatalf("%s: err = %v, want digit limit error", input, err)
+		}
+	}
+	for _, input := range []string{`{"a":12345}`, `{"a":-1.234e300}`, `{"a":"1234567"}`} {
+		if got, err := dedupLine(opts, input); err != nil || got != input {
+			t.Fatalf("%s = %q, %v; want unchanged", input, got, err)
+		}
+	}
+}
+
+func TestNormalizeScientific(t *testing.T) {
+	input := `{"a":1.5e3,"b":[1E-3,2],"c":1e400,"d":"1e3","e":1e20,"f":-9.1e15}`
+	got, err := dedupLine(&options{normalizeScientific: true}, input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := `{"a":1500,"b":[0.001,2],"c":1e400,"d":"1e3","e":"100000000000000000000","f":"-9100000000000000"}`; got != want {
+		t.Fatalf("%s = %s, want %s", input, got, want)
+	}
+	_, err = dedupLine(&options{normalizeScientific: true, scientificStrict: true}, input)
+	if err == nil || !strings.Contains(err.Error(), "number 1e400 is too large to expand") {
+		t.Fatalf("strict: err = %v, want too large to expand", err)
+	}
+}
+
+func TestNegativeZero(t *testing.T) {
+	input := `{"a":-0,"b":-0.0,"c":[-0e5,0,-1],"d":-0,"d":5}`
+	tests := []struct {
+		opts options
+		want string
+	}{
+		{options{}, `{"a":-0,"b":-0.0,"c":[-0e5,0,-1],"d":-0}`},
+		{options{negativeZero: true}, `{"a":0,"b":0.0,"c":[0e5,0,-1],"d":0}`},
+		{options{negativeZero: true, emptyValues: stringList{"0"}}, `{"a":0,"b":0.0,"c":[0e5,0,-1],"d":5}`},
+	}
+	for _, tt := range tests {
+		got, err := dedupLine(&tt.opts, input)
+		if err != nil {
+			t.Fatalf("%+v: unexpected error: %v", tt.opts, err)
+		}
+		if got != tt.want {
+			t.Fatalf("%+v: %s = %s, want %s", tt.opts, input, got, tt.want)
+		}
+	}
+}
+
+// processLine reuses ctx.parser for every record, which is only safe because
+// a converted tree owns its strings.
+func TestConvertedTreeSurvivesParserReuse(t *testing.T) {
+	ctx := &dedupContext{opts: &options{}}
+	value, err := ctx.parser.Parse(`{"a":"first","b":["x"],"n":12345}`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	tree, err := convertFastJSON(value, 0, defaultMaxSafeInt)
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if _, err := ctx.parser.Parse(`{"z":"overwritten","y":["qqqqq"],"m":98765}`); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	var out bytes.Buffer
+	tree.Write(&out)
+	if got, want := out.String(), `{"a":"first","b":["x"],"n":12345}`; got != want {
+		t.Fatalf("tree after parser reuse = %s, want %s", got, want)
+	}
+}
+
+func BenchmarkProcessLine(b *testing.B) {
+	line := []byte(`{"id":123,"name":"","name":"widget","tags":["a","b"],"meta.source":"api","meta.region":null,"meta":{"region":"eu","region":""},"price":12.5}`)
+	ctx := &dedupContext{opts: &options{}}
+	var buf bytes.Buffer
+	b.ReportAllocs()
+	b.SetBytes(int64(len(line)))
+	for i := 0; i < b.N; i++ {
+		if err := processLine(line, &buf, ctx); err != nil {
+			b.Fatal(err)
 		}
 	}
 }
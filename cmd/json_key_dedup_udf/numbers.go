@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// exactNumber is a JSON number parsed without rounding. Integer tokens use
+// big.Int; tokens with a fraction or exponent use big.Rat.
+type exactNumber struct {
+	i *big.Int
+	r *big.Rat
+}
+
+func parseExactNumber(num string) (exactNumber, error) {
+	if strings.ContainsAny(num, ".eE") {
+		r, ok := new(big.Rat).SetString(num)
+		if !ok {
+			return exactNumber{}, fmt.Errorf("invalid number %q", num)
+		}
+		return exactNumber{r: r}, nil
+	}
+	i, ok := new(big.Int).SetString(num, 10)
+	if !ok {
+		return exactNumber{}, fmt.Errorf("invalid number %q", num)
+	}
+	return exactNumber{i: i}, nil
+}
+
+func (n exactNumber) rat() *big.Rat {
+	if n.r != nil {
+		return n.r
+	}
+	return new(big.Rat).SetInt(n.i)
+}
+
+// cmp compares n and other exactly, returning -1, 0 or +1.
+func (n exactNumber) cmp(other exactNumber) int {
+	if n.i != nil && other.i != nil {
+		return n.i.Cmp(other.i)
+	}
+	return n.rat().Cmp(other.rat())
+}
+
+// add returns n+other, staying integral when both operands are.
+func (n exactNumber) add(other exactNumber) exactNumber {
+	if n.i != nil && other.i != nil {
+		return exactNumber{i: new(big.Int).Add(n.i, other.i)}
+	}
+	return exactNumber{r: new(big.Rat).Add(n.rat(), other.rat())}
+}
+
+// String renders n as a plain JSON number without loss. Rationals parsed
+// from JSON always have a finite decimal expansion.
+func (n exactNumber) String() string {
+	if n.i != nil {
+		return n.i.String()
+	}
+	if n.r.IsInt() {
+		return n.r.Num().String()
+	}
+	return n.r.FloatString(decimalPlaces(n.r.Denom()))
+}
+
+// decimalPlaces returns the number of fractional digits needed to print a
+// fraction with the given denominator exactly, assuming it has the form
+// 2^a * 5^b.
+func decimalPlaces(denom *big.Int) int {
+	d := new(big.Int).Set(denom)
+	two, five := big.NewInt(2), big.NewInt(5)
+	var rem big.Int
+	twos, fives := 0, 0
+	for d.Cmp(big.NewInt(1)) > 0 {
+		if rem.Mod(d, two); rem.Sign() == 0 {
+			d.Quo(d, two)
+			twos++
+			continue
+		}
+		if rem.Mod(d, five); rem.Sign() == 0 {
+			d.Quo(d, five)
+			fives++
+			continue
+		}
+		break
+	}
+	if twos > fives {
+		return twos
+	}
+	return fives
+}
+
+// sumNumbers adds JSON number tokens exactly and renders the total.
+func sumNumbers(nums []string) (string, error) {
+	total := exactNumber{i: new(big.Int)}
+	for _, num := range nums {
+		n, err := parseExactNumber(num)
+		if err != nil {
+			return "", err
+		}
+		total = total.add(n)
+	}
+	return total.String(), nil
+}
+
+// isNegativeZero reports whether num is a zero with a leading minus sign,
+// such as -0, -0.00 or -0e5.
+func isNegativeZero(num string) bool {
+	if len(num) < 2 || num[0] != '-' {
+		return false
+	}
+	for i := 1; i < len(num); i++ {
+		switch c := num[i]; {
+		case c == 'e' || c == 'E':
+			return true
+		case c != '0' && c != '.':
+			return false
+		}
+	}
+	return true
+}
+
+// scientificMaxExponent bounds -normalize-scientific: a token whose exponent
+// is larger in magnitude would expand into an unreasonably long number.
+const scientificMaxExponent = 64
+
+// expandScientific rewrites a number token that has an exponent as a plain
+// decimal without loss (1.5e3 becomes 1500). It fails when the exponent is
+// beyond ±scientificMaxExponent. Negative zero keeps its sign.
+func expandScientific(num string) (string, error) {
+	e := strings.IndexAny(num, "eE")
+	if e < 0 {
+		return num, nil
+	}
+	exp, err := strconv.Atoi(num[e+1:])
+	if err != nil || exp > scientificMaxExponent || exp < -scientificMaxExponent {
+		return "", fmt.Errorf("number %s is too large to expand (exponent beyond ±%d)", num, scientificMaxExponent)
+	}
+	n, err := parseExactNumber(num)
+	if err != nil {
+		return "", err
+	}
+	if isNegativeZero(num) {
+		return "-0", nil
+	}
+	return n.String(), nil
+}
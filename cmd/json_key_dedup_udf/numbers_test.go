@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSumNumbersIsExact(t *testing.T) {
+	tests := []struct {
+		nums []string
+		want string
+	}{
+		{[]string{"9223372036854775807", "9223372036854775807", "1"}, "18446744073709551615"},
+		{[]string{"123456789012345678901234567890", "-123456789012345678901234567889"}, "1"},
+		{[]string{"0.1", "0.2"}, "0.3"},
+		{[]string{"1e20", "1"}, "100000000000000000001"},
+		{[]string{"1.5e-3", "2"}, "2.0015"},
+		{[]string{"0.5", "0.5"}, "1"},
+	}
+	for _, tt := range tests {
+		got, err := sumNumbers(tt.nums)
+		if err != nil {
+			t.Fatalf("sumNumbers(%v): unexpected error: %v", tt.nums, err)
+		}
+		if got != tt.want {
+			t.Fatalf("sumNumbers(%v) = %s, want %s", tt.nums, got, tt.want)
+		}
+	}
+}
+
+func TestExpandScientific(t *testing.T) {
+	tests := map[string]string{
+		"1.5e3":    "1500",
+		"1.5E-3":   "0.0015",
+		"-2.50e+2": "-250",
+		"1e6":      "1000000",
+		"12e-1":    "1.2",
+		"-0e5":     "-0",
+		"1.25":     "1.25",
+		"7":        "7",
+		"1e64":     "1" + strings.Repeat("0", 64),
+	}
+	for num, want := range tests {
+		got, err := expandScientific(num)
+		if err != nil {
+			t.Fatalf("expandScientific(%q): unexpected error: %v", num, err)
+		}
+		if got != want {
+			t.Fatalf("expandScientific(%q) = %s, want %s", num, got, want)
+		}
+	}
+	for _, num := range []string{"1e400", "1e-65", "1e99999999999999999999"} {
+		if _, err := expandScientific(num); err == nil {
+			t.Fatalf("expandScientific(%q): expected error, got nil", num)
+		}
+	}
+}
+
+func TestIsNegativeZero(t *testing.T) {
+	tests := map[string]bool{
+		"-0":    true,
+		"-0.0":  true,
+		"-0e5":  true,
+		"-0E-1": true,
+		"0":     false,
+		"-0.01": false,
+		"-10":   false,
+		"-":     false,
+	}
+	for num, want := range tests {
+		if got := isNegativeZero(num); got != want {
+			t.Fatalf("isNegativeZero(%q) = %v, want %v", num, got, want)
+		}
+	}
+}
+
+func TestExactNumberCmp(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"18446744073709551616", "18446744073709551615", 1},
+		{"1.0", "1", 0},
+		{"-0", "0", 0},
+		{"1e-30", "0", 1},
+		{"2.5", "25e-1", 0},
+	}
+	for _, tt := range tests {
+		a, err := parseExactNumber(tt.a)
+		if err != nil {
+			t.Fatalf("parse %s: %v", tt.a, err)
+		}
+		b, err := parseExactNumber(tt.b)
+		if err != nil {
+			t.Fatalf("parse %s: %v", tt.b, err)
+		}
+		if got := a.cmp(b); got != tt.want {
+			t.Fatalf("cmp(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
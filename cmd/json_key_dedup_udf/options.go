@@ -0,0 +1,446 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// Policies for duplicate keys whose candidates mix containers and scalars.
+const (
+	conflictDefault    = ""
+	conflictKeepObject = "keep-object"
+	conflictKeepScalar = "keep-scalar"
+	conflictError      = "error"
+)
+
+// Policies for -resolve-policy, which picks between duplicate occurrences by
+// value instead of by position.
+const (
+	policyDefault  = ""
+	policyLargest  = "largest"
+	policySmallest = "smallest"
+	policyNumMax   = "numeric-max"
+	policyNumMin   = "numeric-min"
+)
+
+// Orderings for -keep-key-order.
+const (
+	keyOrderSource      = "source"
+	keyOrderSorted      = "sorted"
+	keyOrderAlphaNested = "alpha-nested"
+)
+
+// Modes for -blank-line.
+const (
+	blankLineError       = "error"
+	blankLineSkip        = "skip"
+	blankLineEmptyObject = "empty-object"
+)
+
+// Modes for -trailing-newline. The zero value behaves like auto.
+const (
+	trailingNewlineAuto   = "auto"
+	trailingNewlineAlways = "always"
+	trailingNewlineNever  = "never"
+)
+
+// Input shapes for -input-format. The zero value reads NDJSON.
+const (
+	inputFormatAuto   = "auto"
+	inputFormatJSON   = "json"
+	inputFormatNDJSON = "ndjson"
+)
+
+// Key styles for -key-case.
+const (
+	keyCaseNone  = "none"
+	keyCaseSnake = "snake"
+	keyCaseCamel = "camel"
+)
+
+// Forms for -normalize-unicode-values.
+const (
+	unicodeNFC  = "NFC"
+	unicodeNFKC = "NFKC"
+)
+
+// Character sets for -input-charset.
+const (
+	charsetUTF8   = "utf-8"
+	charsetLatin1 = "latin1"
+)
+
+// Choices for -tie-break.
+const (
+	tieBreakFirst = "first"
+	tieBreakLast  = "last"
+)
+
+// Directions for -normalize-empty-array.
+const (
+	emptyArrayToNull   = "to-null"
+	emptyArrayFromNull = "from-null"
+)
+
+type options struct {
+	countOnly            bool
+	scalarObjectConflict string
+	selectPaths          stringList
+	selectFlat           bool
+	requireTopObject     bool
+	lowercaseKeys        bool
+	idFrom               stringList
+	expandKeys           stringList
+	inputDelim           string
+	normalizeUnderscores bool
+	normalizeBools       stringList
+	suffixDuplicates     bool
+	maxRecordSize        int
+	normalizeTimestamps  stringList
+	outputURL            string
+	batchLines           int
+	postCmd              string
+	outPattern           string
+	preserveAmbiguous    bool
+	dropEmptyRecords     bool
+	schemaFile           string
+	schema               *jsonschema.Schema
+	defaultsFile         string
+	defaults             *objectNode
+	templateFile         string
+	template             *objectNode
+	templateOmitMissing  bool
+	enrichFile           string
+	enrich               *objectNode
+	enrichOverwrite      bool
+	keyPrefix            string
+	keySuffix            string
+	keyAffixDepth        int
+	emitBOM              bool
+	emptyArray           string
+	nullMissing          stringList
+	flushEvery           int
+	maxNumberDigits      int
+	maxSafeInt           string
+	negativeZero         bool
+	normalizeScientific  bool
+	scientificStrict     bool
+	ignoreEmptyHeuristic bool
+	stripControl         bool
+	keepControlSpace     bool
+	decodeEmbedded       stringList
+	collapseSpace        bool
+	collapseSpaceTrim    bool
+	collapseSpaceKeys    bool
+	unescapeHTML         bool
+	maxStringLen         int
+	maxStringRunes       bool
+	maxStringMarker      string
+	maxStringKeys        bool
+	dedupReport          string
+	collisionAudit       string
+	resolvePolicy        string
+	wrapArray            bool
+	inputJSONArray       bool
+	inputFormat          string
+	keyOrder             string
+	escapeSlash          bool
+	dedupMaxDepth        int
+	blankLine            string
+	trailingNewline      string
+	annotateDups         bool
+	spillDuplicates      bool
+	trace                bool
+	changedOnly          bool
+	limit                int
+	startLine            int
+	endLine              int
+	emptyValues          stringList
+	keyCase              string
+	arrayDedupBy         string
+	unicodeForm          string
+	inputCharset         string
+	rejectNonUTF8        bool
+	replaceInvalid       bool
+	invalidUTF8Repl      string
+	timeLines            time.Duration
+	warnDupsOver         int
+	readBuffer           int
+	where                []whereCond
+	dropIdenticalPairs   bool
+	noDedupKeys          stringList
+	unicodeEqual         bool
+	linePrefixRegex      string
+	linePrefix           *regexp.Regexp
+	outFormat            string
+	outFormatMissing     string
+	outFormatTmpl        *outputFormat
+	objectElements       stringList
+	tieBreak             string
+}
+
+func (o *options) validate() error {
+	switch o.scalarObjectConflict {
+	case conflictDefault, conflictKeepObject, conflictKeepScalar, conflictError:
+	default:
+		return fmt.Errorf("invalid -scalar-object-conflict %q: want keep-object, keep-scalar or error", o.scalarObjectConflict)
+	}
+	switch o.resolvePolicy {
+	case policyDefault, policyLargest, policySmallest, policyNumMax, policyNumMin:
+	default:
+		return fmt.Errorf("invalid -resolve-policy %q: want largest, smallest, numeric-max or numeric-min", o.resolvePolicy)
+	}
+	switch o.keyOrder {
+	case "", keyOrderSource, keyOrderSorted, keyOrderAlphaNested:
+	default:
+		return fmt.Errorf("invalid -keep-key-order %q: want source, sorted or alpha-nested", o.keyOrder)
+	}
+	switch o.blankLine {
+	case "", blankLineError, blankLineSkip, blankLineEmptyObject:
+	default:
+		return fmt.Errorf("invalid -blank-line %q: want skip, empty-object or error", o.blankLine)
+	}
+	switch o.trailingNewline {
+	case "", trailingNewlineAuto, trailingNewlineAlways, trailingNewlineNever:
+	default:
+		return fmt.Errorf("invalid -trailing-newline %q: want auto, always or never", o.trailingNewline)
+	}
+	switch o.inputFormat {
+	case "", inputFormatAuto, inputFormatJSON, inputFormatNDJSON:
+	default:
+		return fmt.Errorf("invalid -input-format %q: want auto, json or ndjson", o.inputFormat)
+	}
+	if o.inputJSONArray && o.inputFormat == inputFormatNDJSON {
+		return fmt.Errorf("-input-json-array cannot be combined with -input-format ndjson")
+	}
+	switch o.keyCase {
+	case "", keyCaseNone, keyCaseSnake, keyCaseCamel:
+	default:
+		return fmt.Errorf("invalid -key-case %q: want snake, camel or none", o.keyCase)
+	}
+	switch o.unicodeForm {
+	case "", unicodeNFC, unicodeNFKC:
+	default:
+		return fmt.Errorf("invalid -normalize-unicode-values %q: want NFC or NFKC", o.unicodeForm)
+	}
+	switch o.inputCharset {
+	case "", charsetUTF8, charsetLatin1:
+	default:
+		return fmt.Errorf("invalid -input-charset %q: want utf-8 or latin1", o.inputCharset)
+	}
+	switch o.tieBreak {
+	case "", tieBreakFirst, tieBreakLast:
+	default:
+		return fmt.Errorf("invalid -tie-break %q: want first or last", o.tieBreak)
+	}
+	switch o.emptyArray {
+	case "", emptyArrayToNull, emptyArrayFromNull:
+	default:
+		return fmt.Errorf("invalid -normalize-empty-array %q: want to-null or from-null", o.emptyArray)
+	}
+	if o.batchLines < 0 {
+		return fmt.Errorf("invalid -batch-lines %d: must not be negative", o.batchLines)
+	}
+	if o.batchLines > 0 && !strings.Contains(o.outPattern, "%d") {
+		return fmt.Errorf("-batch-lines requires -out-pattern containing %%d")
+	}
+	if o.batchLines > 0 && o.outputURL != "" {
+		return fmt.Errorf("-batch-lines cannot be combined with -output-url")
+	}
+	if o.maxNumberDigits < 0 {
+		return fmt.Errorf("invalid -max-number-digits %d: must not be negative", o.maxNumberDigits)
+	}
+	if o.maxSafeInt == "0" {
+		return fmt.Errorf("invalid -max-safe-int 0: must be positive; omit it for the default 2^53-1")
+	}
+	if o.startLine < 0 || o.endLine < 0 {
+		return fmt.Errorf("invalid -start-line %d / -end-line %d: must not be negative", o.startLine, o.endLine)
+	}
+	if o.endLine > 0 && o.endLine < o.startLine {
+		return fmt.Errorf("-end-line %d is before -start-line %d", o.endLine, o.startLine)
+	}
+	if o.readBuffer < 0 {
+		return fmt.Errorf("invalid -read-buffer %d: must not be negative", o.readBuffer)
+	}
+	if o.warnDupsOver < 0 {
+		return fmt.Errorf("invalid -warn-dups-over %d: must not be negative", o.warnDupsOver)
+	}
+	if o.timeLines < 0 {
+		return fmt.Errorf("invalid -time-lines %s: must not be negative", o.timeLines)
+	}
+	if o.maxStringLen < 0 {
+		return fmt.Errorf("invalid -max-string-len %d: must not be negative", o.maxStringLen)
+	}
+	if o.limit < 0 {
+		return fmt.Errorf("invalid -limit %d: must not be negative", o.limit)
+	}
+	if o.flushEvery < 0 {
+		return fmt.Errorf("invalid -flush-every %d: must not be negative", o.flushEvery)
+	}
+	if o.dedupMaxDepth < 0 {
+		return fmt.Errorf("invalid -dedup-max-depth %d: must not be negative", o.dedupMaxDepth)
+	}
+	if o.keyAffixDepth < 0 {
+		return fmt.Errorf("invalid -key-affix-depth %d: must not be negative", o.keyAffixDepth)
+	}
+	if o.wrapArray && (o.countOnly || o.batchLines > 0 || o.outputURL != "") {
+		return fmt.Errorf("-wrap-array cannot be combined with -count-only, -batch-lines or -output-url")
+	}
+	if o.outFormat != "" && o.wrapArray {
+		return fmt.Errorf("-out-format cannot be combined with -wrap-array")
+	}
+	if o.rejectNonUTF8 && o.replaceInvalid {
+		return fmt.Errorf("-reject-non-utf8 cannot be combined with -replace-invalid-utf8")
+	}
+	if o.postCmd != "" && (o.wrapArray || o.batchLines > 0 || o.outputURL != "") {
+		return fmt.Errorf("-post-cmd cannot be combined with -wrap-array, -batch-lines or -output-url")
+	}
+	if len(o.selectPaths) > 0 && o.templateFile != "" {
+		return fmt.Errorf("-select cannot be combined with -template")
+	}
+	// -suffix-duplicates keeps every occurrence, so options that pick one of
+	// them would be silently ignored.
+	if o.suffixDuplicates && o.scalarObjectConflict != conflictDefault {
+		return fmt.Errorf("-suffix-duplicates cannot be combined with -scalar-object-conflict")
+	}
+	if o.suffixDuplicates && o.resolvePolicy != policyDefault {
+		return fmt.Errorf("-suffix-duplicates cannot be combined with -resolve-policy")
+	}
+	if o.suffixDuplicates && o.spillDuplicates {
+		return fmt.Errorf("-suffix-duplicates cannot be combined with -spill-duplicates")
+	}
+	if o.suffixDuplicates && o.ignoreEmptyHeuristic {
+		return fmt.Errorf("-suffix-duplicates cannot be combined with -ignore-empty-heuristic")
+	}
+	return nil
+}
+
+// load reads the files and compiles the patterns named by the options.
+func (o *options) load() error {
+	if o.linePrefixRegex != "" {
+		re, err := regexp.Compile(o.linePrefixRegex)
+		if err != nil {
+			return fmt.Errorf("line prefix regex error: %w", err)
+		}
+		o.linePrefix = re
+	}
+	if o.outFormat != "" {
+		format, err := parseOutputFormat(o.outFormat)
+		if err != nil {
+			return fmt.Errorf("out format error: %w", err)
+		}
+		o.outFormatTmpl = format
+	}
+	if o.defaultsFile != "" {
+		defaults, err := loadObjectFile(o.defaultsFile, o.safeIntDigits())
+		if err != nil {
+			return fmt.Errorf("defaults load error: %w", err)
+		}
+		o.defaults = defaults
+	}
+	if o.schemaFile != "" {
+		schema, err := loadJSONSchema(o.schemaFile)
+		if err != nil {
+			return fmt.Errorf("jsonschema load error: %w", err)
+		}
+		o.schema = schema
+	}
+	if o.enrichFile != "" {
+		enrich, err := loadObjectFile(o.enrichFile, o.safeIntDigits())
+		if err != nil {
+			return fmt.Errorf("enrich load error: %w", err)
+		}
+		o.enrich = enrich
+	}
+	if o.templateFile != "" {
+		template, err := loadObjectFile(o.templateFile, o.safeIntDigits())
+		if err != nil {
+			return fmt.Errorf("template load error: %w", err)
+		}
+		o.template = template
+	}
+	return nil
+}
+
+// safeIntDigits returns -max-safe-int in decimal, the default 2^53-1 when
+// it is unset.
+func (o *options) safeIntDigits() string {
+	if o.maxSafeInt == "" {
+		return defaultMaxSafeInt
+	}
+	return o.maxSafeInt
+}
+
+// bufferSize returns the size of the stdin and stdout buffers in bytes.
+func (o *options) bufferSize() int {
+	if o.readBuffer > 0 {
+		return o.readBuffer * 1024
+	}
+	return readBufferSize
+}
+
+// terminateRecord reports whether an output record is followed by the
+// delimiter, given whether its input record was.
+func (o *options) terminateRecord(inputTerminated bool) bool {
+	if o.wrapArray {
+		return false
+	}
+	switch o.trailingNewline {
+	case trailingNewlineAlways:
+		return true
+	case trailingNewlineNever:
+		return false
+	}
+	return inputTerminated
+}
+
+// recordDelim returns the byte separating input records, newline unless
+// -input-delim set another one.
+func (o *options) recordDelim() byte {
+	if o.inputDelim == "" {
+		return '\n'
+	}
+	return o.inputDelim[0]
+}
+
+// parseDelim decodes an -input-delim value. Only control characters are
+// accepted: the writer escapes every byte below 0x20 inside strings, so the
+// delimiter can never appear unescaped in an output record.
+func parseDelim(value string) (byte, error) {
+	decoded := value
+	if value == `\0` {
+		decoded = "\x00"
+	} else if strings.HasPrefix(value, `\`) {
+		unquoted, err := strconv.Unquote(`"` + value + `"`)
+		if err != nil {
+			return 0, fmt.Errorf("invalid delimiter %q", value)
+		}
+		decoded = unquoted
+	}
+	if len(decoded) != 1 || decoded[0] >= 0x20 {
+		return 0, fmt.Errorf("invalid delimiter %q: want a single control character such as \\n or \\0", value)
+	}
+	return decoded[0], nil
+}
+
+// stringList is a flag.Value holding a comma-separated list.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = (*l)[:0]
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import "testing"
+
+func TestParseDelim(t *testing.T) {
+	tests := map[string]byte{
+		`\n`:   '\n',
+		`\0`:   0,
+		`\t`:   '\t',
+		`\x1e`: 0x1e,
+		"\x00": 0,
+	}
+	for input, want := range tests {
+		got, err := parseDelim(input)
+		if err != nil {
+			t.Fatalf("parseDelim(%q): unexpected error: %v", input, err)
+		}
+		if got != want {
+			t.Fatalf("parseDelim(%q) = %#x, want %#x", input, got, want)
+		}
+	}
+
+	for _, input := range []string{",", "ab", `\q`, ""} {
+		if _, err := parseDelim(input); err == nil {
+			t.Fatalf("parseDelim(%q): expected error, got nil", input)
+		}
+	}
+}
+
+func TestValidateBatchOptions(t *testing.T) {
+	tests := []struct {
+		opts    options
+		wantErr bool
+	}{
+		{options{batchLines: 10, outPattern: "out-%d.ndjson"}, false},
+		{options{batchLines: 10}, true},
+		{options{batchLines: 10, outPattern: "out.ndjson"}, true},
+		{options{batchLines: 10, outPattern: "out-%d.ndjson", outputURL: "tcp://127.0.0.1:9000"}, true},
+		{options{batchLines: -1}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.opts.validate(); (err != nil) != tt.wantErr {
+			t.Fatalf("validate(batchLines=%d, outPattern=%q, outputURL=%q) error = %v, wantErr %v",
+				tt.opts.batchLines, tt.opts.outPattern, tt.opts.outputURL, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateExclusiveOptions(t *testing.T) {
+	tests := []struct {
+		opts options
+		want string
+	}{
+		{options{suffixDuplicates: true, scalarObjectConflict: conflictKeepObject}, "-suffix-duplicates cannot be combined with -scalar-object-conflict"},
+		{options{suffixDuplicates: true, ignoreEmptyHeuristic: true}, "-suffix-duplicates cannot be combined with -ignore-empty-heuristic"},
+		{options{selectPaths: stringList{"a"}, templateFile: "t.json"}, "-select cannot be combined with -template"},
+		{options{suffixDuplicates: true, resolvePolicy: policyLargest}, "-suffix-duplicates cannot be combined with -resolve-policy"},
+		{options{resolvePolicy: "longest"}, `invalid -resolve-policy "longest": want largest, smallest, numeric-max or numeric-min`},
+		{options{wrapArray: true, countOnly: true}, "-wrap-array cannot be combined with -count-only, -batch-lines or -output-url"},
+		{options{suffixDuplicates: true, spillDuplicates: true}, "-suffix-duplicates cannot be combined with -spill-duplicates"},
+		{options{startLine: 5, endLine: 4}, "-end-line 4 is before -start-line 5"},
+		{options{rejectNonUTF8: true, replaceInvalid: true}, "-reject-non-utf8 cannot be combined with -replace-invalid-utf8"},
+		{options{outFormat: "{a}", wrapArray: true}, "-out-format cannot be combined with -wrap-array"},
+		{options{postCmd: "cat", outputURL: "tcp://localhost:9000"}, "-post-cmd cannot be combined with -wrap-array, -batch-lines or -output-url"},
+		{options{maxSafeInt: "0"}, "invalid -max-safe-int 0: must be positive; omit it for the default 2^53-1"},
+		{options{suffixDuplicates: true}, ""},
+		{options{scalarObjectConflict: conflictError, ignoreEmptyHeuristic: true}, ""},
+	}
+	for _, tt := range tests {
+		err := tt.opts.validate()
+		if tt.want == "" {
+			if err != nil {
+				t.Fatalf("validate(%+v): unexpected error: %v", tt.opts, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.want {
+			t.Fatalf("validate(%+v) error = %v, want %q", tt.opts, err, tt.want)
+		}
+	}
+}
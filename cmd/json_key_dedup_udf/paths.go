@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const pathSeparator = "."
+
+// lookupPath follows a dotted path through nested objects and returns the
+// value it names, or nil when any segment is missing.
+func lookupPath(n node, path string) node {
+	for _, segment := range strings.Split(path, pathSeparator) {
+		obj, ok := n.(*objectNode)
+		if !ok {
+			return nil
+		}
+		n = nil
+		for _, entry := range obj.entries {
+			if entry.key == segment {
+				n = entry.value
+				break
+			}
+		}
+		if n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// setPath stores value under a dotted path in obj, creating intermediate
+// objects as needed. An existing non-object value on the way is replaced.
+func setPath(obj *objectNode, path string, value node) {
+	segments := strings.Split(path, pathSeparator)
+	for _, segment := range segments[:len(segments)-1] {
+		var next *objectNode
+		for i := range obj.entries {
+			if obj.entries[i].key != segment {
+				continue
+			}
+			if child, ok := obj.entries[i].value.(*objectNode); ok {
+				next = child
+			} else {
+				next = &objectNode{}
+				obj.entries[i].value = next
+			}
+			break
+		}
+		if next == nil {
+			next = &objectNode{}
+			obj.entries = append(obj.entries, objectEntry{key: segment, value: next})
+		}
+		obj = next
+	}
+	last := segments[len(segments)-1]
+	for i := range obj.entries {
+		if obj.entries[i].key == last {
+			obj.entries[i].value = value
+			return
+		}
+	}
+	obj.entries = append(obj.entries, objectEntry{key: last, value: value})
+}
+
+// selectPaths projects the listed paths of a deduplicated record into a new
+// object. Missing paths are omitted. With flat set the paths become literal
+// top-level keys instead of nested objects. The returned tree shares leaf
+// nodes with n and must not be recycled.
+func selectPaths(n node, paths []string, flat bool) *objectNode {
+	result := &objectNode{}
+	for _, path := range paths {
+		value := lookupPath(n, path)
+		if value == nil {
+			continue
+		}
+		if flat {
+			result.entries = append(result.entries, objectEntry{key: path, value: value})
+		} else {
+			setPath(result, path, value)
+		}
+	}
+	return result
+}
+
+// checkObjectElements returns an error when an array at one of paths holds
+// an element that is not an object. "$" names the record itself; paths that
+// are missing or do not hold an array are skipped.
+func checkObjectElements(n node, paths []string) error {
+	for _, path := range paths {
+		target := n
+		if path != templatePathPrefix {
+			target = lookupPath(n, path)
+		}
+		arr, ok := target.(*arrayNode)
+		if !ok {
+			continue
+		}
+		for i, item := range arr.values {
+			if _, ok := item.(*objectNode); !ok {
+				return fmt.Errorf("array %s: element %d is %s, want object", path, i, nodeTypeName(item))
+			}
+		}
+	}
+	return nil
+}
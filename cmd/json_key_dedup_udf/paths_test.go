@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestSelectPaths(t *testing.T) {
+	input := `{"a":{"b":1,"b":2,"c":3},"c":"x","d":true}`
+	tests := []struct {
+		paths []string
+		flat  bool
+		want  string
+	}{
+		{[]string{"a.b", "c"}, false, `{"a":{"b":1},"c":"x"}`},
+		{[]string{"a.b", "c"}, true, `{"a.b":1,"c":"x"}`},
+		{[]string{"a.c", "a.b"}, false, `{"a":{"c":3,"b":1}}`},
+		{[]string{"missing", "a.missing", "d"}, false, `{"d":true}`},
+	}
+
+	for _, tt := range tests {
+		got, err := dedupLine(&options{selectPaths: tt.paths, selectFlat: tt.flat}, input)
+		if err != nil {
+			t.Fatalf("select %v: unexpected error: %v", tt.paths, err)
+		}
+		if got != tt.want {
+			t.Fatalf("select %v (flat=%v) = %s, want %s", tt.paths, tt.flat, got, tt.want)
+		}
+	}
+}
+
+func TestRequireObjectElements(t *testing.T) {
+	opts := &options{objectElements: stringList{"events", "meta.items", "$"}}
+	for _, input := range []string{
+		`{"events":[{"a":1},{"b":2}],"meta":{"items":[]}}`,
+		`{"other":[1,2],"events":"not an array"}`,
+		`[{"a":1,"a":2}]`,
+	} {
+		if _, err := dedupLine(opts, input); err != nil {
+			t.Fatalf("%s: unexpected error: %v", input, err)
+		}
+	}
+
+	tests := map[string]string{
+		`{"events":[{"a":1},5]}`:             "array events: element 1 is number, want object",
+		`{"meta.items":[{"a":1},[1]]}`:       "array meta.items: element 1 is array, want object",
+		`[{"a":1},null]`:                     "array $: element 1 is null, want object",
+		`{"events":[{"a":1},{"a":2},"str"]}`: "array events: element 2 is string, want object",
+	}
+	for input, want := range tests {
+		if _, err := dedupLine(opts, input); err == nil || err.Error() != want {
+			t.Fatalf("%s: err = %v, want %q", input, err, want)
+		}
+	}
+}
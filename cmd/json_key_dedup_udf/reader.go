@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/valyala/fastjson"
+)
+
+const (
+	readBufferSize       = 4 * 1024 * 1024
+	defaultMaxRecordSize = 1 << 30
+)
+
+// recordSource yields the input records processed by run.
+type recordSource interface {
+	Scan() bool
+	Record() []byte
+	Terminated() bool
+	Err() error
+}
+
+// recordScanner splits input into records on a single delimiter byte. Unlike
+// a default bufio.Scanner it accepts records up to maxSize bytes, and it
+// reports whether each record was terminated by the delimiter so the output
+// can mirror the input's final line ending.
+type recordScanner struct {
+	scanner    *bufio.Scanner
+	delim      byte
+	maxSize    int
+	terminated bool
+}
+
+// newRecordScanner starts with a bufSize buffer, growing it as needed up to
+// maxSize.
+func newRecordScanner(r io.Reader, delim byte, maxSize, bufSize int) *recordScanner {
+	if maxSize <= 0 {
+		maxSize = defaultMaxRecordSize
+	}
+	initial := bufSize
+	if initial > maxSize {
+		initial = maxSize
+	}
+
+	rs := &recordScanner{scanner: bufio.NewScanner(r), delim: delim, maxSize: maxSize}
+	rs.scanner.Buffer(make([]byte, 0, initial), maxSize)
+	rs.scanner.Split(rs.split)
+	return rs
+}
+
+func (rs *recordScanner) split(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, rs.delim); i >= 0 {
+		rs.terminated = true
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		rs.terminated = false
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// Scan advances to the next record, returning false at EOF or on error.
+func (rs *recordScanner) Scan() bool {
+	return rs.scanner.Scan()
+}
+
+// Record returns the current record without its delimiter. A trailing \r is
+// also dropped when records are newline-delimited. The slice is only valid
+// until the next call to Scan.
+func (rs *recordScanner) Record() []byte {
+	record := rs.scanner.Bytes()
+	if rs.delim == '\n' && len(record) > 0 && record[len(record)-1] == '\r' {
+		record = record[:len(record)-1]
+	}
+	return record
+}
+
+// Terminated reports whether the current record ended with the delimiter.
+func (rs *recordScanner) Terminated() bool {
+	return rs.terminated
+}
+
+func (rs *recordScanner) Err() error {
+	err := rs.scanner.Err()
+	if errors.Is(err, bufio.ErrTooLong) {
+		return fmt.Errorf("record exceeds %d bytes (raise -max-record-size)", rs.maxSize)
+	}
+	return err
+}
+
+// arrayScanner reads the whole input as one JSON document and yields each
+// element of a top-level array as a record. Unless requireArray is set, any
+// other document is yielded as a single record.
+type arrayScanner struct {
+	in           io.Reader
+	maxSize      int
+	requireArray bool
+	parser       fastjson.Parser
+	values       []*fastjson.Value
+	next         int
+	record       []byte
+	started      bool
+	err          error
+}
+
+func newArrayScanner(r io.Reader, maxSize int, requireArray bool) *arrayScanner {
+	if maxSize <= 0 {
+		maxSize = defaultMaxRecordSize
+	}
+	return &arrayScanner{in: r, maxSize: maxSize, requireArray: requireArray}
+}
+
+func (as *arrayScanner) load() error {
+	data, err := io.ReadAll(io.LimitReader(as.in, int64(as.maxSize)+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > as.maxSize {
+		return fmt.Errorf("input document exceeds %d bytes (raise -max-record-size)", as.maxSize)
+	}
+	value, err := as.parser.ParseBytes(data)
+	if err != nil {
+		return fmt.Errorf("input document parse error: %w", err)
+	}
+	if value.Type() != fastjson.TypeArray {
+		if as.requireArray {
+			return fmt.Errorf("expected the input to be a JSON array, got %s", jsonTypeName(value.Type()))
+		}
+		as.values = []*fastjson.Value{value}
+		return nil
+	}
+	as.values, _ = value.Array()
+	return nil
+}
+
+// Scan advances to the next array element. The whole input is read and
+// parsed on the first call.
+func (as *arrayScanner) Scan() bool {
+	if !as.started {
+		as.started = true
+		as.err = as.load()
+	}
+	if as.err != nil || as.next >= len(as.values) {
+		return false
+	}
+	as.record = as.values[as.next].MarshalTo(as.record[:0])
+	as.next++
+	return true
+}
+
+// Record returns the current element re-encoded as compact JSON.
+func (as *arrayScanner) Record() []byte {
+	return as.record
+}
+
+// Terminated is always true so every element is written as its own line.
+func (as *arrayScanner) Terminated() bool {
+	return true
+}
+
+func (as *arrayScanner) Err() error {
+	return as.err
+}
+
+// detectInputFormat picks between NDJSON and a single JSON document for
+// -input-format auto. The input is NDJSON when its first non-blank record
+// parses on its own. The returned reader replays everything that was read.
+func detectInputFormat(in io.Reader, delim byte, bufSize int) (string, io.Reader, error) {
+	br := bufio.NewReaderSize(in, bufSize)
+	var peeked []byte
+	for {
+		line, err := br.ReadBytes(delim)
+		peeked = append(peeked, line...)
+		if err != nil && err != io.EOF {
+			return "", nil, err
+		}
+		line = bytes.TrimSpace(bytes.TrimSuffix(line, []byte{delim}))
+		if len(line) == 0 && err == nil {
+			continue
+		}
+		format := inputFormatJSON
+		if len(line) == 0 || fastjson.ValidateBytes(line) == nil {
+			format = inputFormatNDJSON
+		}
+		return format, io.MultiReader(bytes.NewReader(peeked), br), nil
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRunHandlesMultiMegabyteLine(t *testing.T) {
+	long := strings.Repeat("x", 8*1024*1024)
+	input := `{"a":"` + long + `","a":"y"}` + "\r\n" + `{"b":1}`
+
+	var out bytes.Buffer
+	if err := run(strings.NewReader(input), &out, &options{}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	want := `{"a":"` + long + `"}` + "\n" + `{"b":1}`
+	if out.String() != want {
+		t.Fatalf("output mismatch: got %d bytes, want %d bytes", out.Len(), len(want))
+	}
+}
+
+func TestRunSmallReadBuffer(t *testing.T) {
+	long := strings.Repeat("y", 100*1024)
+	input := `{"a":"","a":"` + long + `"}` + "\n" + `{"b":1,"b":2}` + "\n" + `{"c":"` + long + `"}`
+	var out bytes.Buffer
+	if err := run(strings.NewReader(input), &out, &options{readBuffer: 1}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	want := `{"a":"` + long + `"}` + "\n" + `{"b":1}` + "\n" + `{"c":"` + long + `"}`
+	if out.String() != want {
+		t.Fatalf("output mismatch: got %d bytes, want %d bytes", out.Len(), len(want))
+	}
+}
+
+func BenchmarkRunReadBuffer(b *testing.B) {
+	record := `{"id":1,"blob":"` + strings.Repeat("z", 256*1024) + `","blob":""}` + "\n"
+	input := strings.Repeat(record, 16)
+	for _, kb := range []int{4, 64, 4096} {
+		b.Run(fmt.Sprintf("%dKB", kb), func(b *testing.B) {
+			opts := &options{readBuffer: kb}
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				if err := run(strings.NewReader(input), io.Discard, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestRecordScannerReportsOversizedRecord(t *testing.T) {
+	scanner := newRecordScanner(strings.NewReader(strings.Repeat("x", 1024)+"\n"), '\n', 512, readBufferSize)
+	if scanner.Scan() {
+		t.Fatal("expected Scan to fail on an oversized record")
+	}
+	if err := scanner.Err(); err == nil || !strings.Contains(err.Error(), "-max-record-size") {
+		t.Fatalf("Err() = %v, want a -max-record-size error", err)
+	}
+}
+
+func TestRecordScannerTracksTermination(t *testing.T) {
+	scanner := newRecordScanner(strings.NewReader("a\r\n\nb"), '\n', 0, readBufferSize)
+	var records []string
+	var terminated []bool
+	for scanner.Scan() {
+		records = append(records, string(scanner.Record()))
+		terminated = append(terminated, scanner.Terminated())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+	if got := strings.Join(records, "|"); got != "a||b" {
+		t.Fatalf("records = %q, want %q", got, "a||b")
+	}
+	if terminated[0] != true || terminated[1] != true || terminated[2] != false {
+		t.Fatalf("terminated = %v, want [true true false]", terminated)
+	}
+}
+
+func TestRunInputJSONArray(t *testing.T) {
+	input := "[\n  {\"a\": 1, \"a\": 2},\n  {\n    \"b\": null,\n    \"b\": \"x\"\n  },\n  [1, 2],\n  \"s\",\n  {\"q\": \"a\\\"\\u00e9\\n\"}\n]\n"
+	var out bytes.Buffer
+	if err := run(strings.NewReader(input), &out, &options{inputJSONArray: true}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got, want := out.String(), "{\"a\":1}\n{\"b\":\"x\"}\n[1,2]\n\"s\"\n{\"q\":\"a\\\"é\\n\"}\n"; got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+
+	out.Reset()
+	if err := run(strings.NewReader(" [ ] "), &out, &options{inputJSONArray: true}); err != nil || out.Len() != 0 {
+		t.Fatalf("empty array = %q, %v; want no output", out.String(), err)
+	}
+
+	for _, input := range []string{`{"a":1}`, `[1,`, ""} {
+		if err := run(strings.NewReader(input), &out, &options{inputJSONArray: true}); err == nil {
+			t.Fatalf("run(%q): expected error, got nil", input)
+		}
+	}
+	if err := run(strings.NewReader(`[1,2,3]`), &out, &options{inputJSONArray: true, maxRecordSize: 4}); err == nil ||
+		!strings.Contains(err.Error(), "-max-record-size") {
+		t.Fatalf("oversized array: err = %v, want a -max-record-size error", err)
+	}
+}
+
+// runWithFlags parses args through the real flag definitions, so flag
+// defaults are exercised, and runs input through the result.
+func runWithFlags(t *testing.T, args []string, input string) (string, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	opts := &options{}
+	registerFlags(fs, opts)
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("parse %v: %v", args, err)
+	}
+	if err := opts.validate(); err != nil {
+		t.Fatalf("validate %v: %v", args, err)
+	}
+	if err := opts.load(); err != nil {
+		t.Fatalf("load %v: %v", args, err)
+	}
+	var out bytes.Buffer
+	err := run(strings.NewReader(input), &out, opts)
+	return out.String(), err
+}
+
+func TestRunInputFormatDefaultKeepsNDJSONFeatures(t *testing.T) {
+	tests := []struct {
+		args  []string
+		input string
+		want  string
+	}{
+		{
+			[]string{"-line-prefix-regex", `^\S+ \S+`},
+			"2024-01-01 INFO {\"a\":1,\"a\":2}\n2024-01-02 WARN {\"b\":1}\n",
+			"2024-01-01 INFO {\"a\":1}\n2024-01-02 WARN {\"b\":1}\n",
+		},
+		{[]string{"-start-line", "2"}, "garbage\n{\"a\":1,\"a\":2}\n", "{\"a\":1}\n"},
+		{
+			[]string{"-input-format", "auto", "-line-prefix-regex", `^\S+ \S+`},
+			"2024-01-01 INFO {\"a\":1,\"a\":2}\n",
+			"2024-01-01 INFO {\"a\":1}\n",
+		},
+		{[]string{"-input-format", "auto", "-start-line", "2"}, "garbage\n{\"a\":1,\"a\":2}\n", "{\"a\":1}\n"},
+	}
+	for _, tt := range tests {
+		got, err := runWithFlags(t, tt.args, tt.input)
+		if err != nil {
+			t.Fatalf("%v: run: %v", tt.args, err)
+		}
+		if got != tt.want {
+			t.Fatalf("%v: output = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	// A malformed first record is a line error by default, not a document.
+	_, err := runWithFlags(t, nil, "{\n\"a\":1}\n")
+	if err == nil || !strings.Contains(err.Error(), "line processing error") {
+		t.Fatalf("malformed first line: err = %v, want a line processing error", err)
+	}
+}
+
+func TestRunInputFormat(t *testing.T) {
+	tests := []struct {
+		format string
+		input  string
+		want   string
+	}{
+		{inputFormatAuto, "\n{\"a\":1,\"a\":2}\n{\"b\":3}\n", "{\"a\":1}\n{\"b\":3}\n"},
+		{inputFormatAuto, "[\n  {\"a\":1,\"a\":2},\n  {\"b\":3}\n]\n", "{\"a\":1}\n{\"b\":3}\n"},
+		{inputFormatAuto, "{\n  \"a\": null,\n  \"a\": 1\n}\n", "{\"a\":1}\n"},
+		{inputFormatAuto, "[{\"a\":1},{\"a\":2}]\n", "[{\"a\":1},{\"a\":2}]\n"},
+		{inputFormatAuto, "", ""},
+		{inputFormatJSON, "[{\"a\":1},{\"a\":2}]\n", "{\"a\":1}\n{\"a\":2}\n"},
+		{inputFormatJSON, "{\"a\":1}", "{\"a\":1}\n"},
+		{inputFormatNDJSON, "{\"a\":1}\n[2]", "{\"a\":1}\n[2]"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if err := run(strings.NewReader(tt.input), &out, &options{inputFormat: tt.format, blankLine: blankLineSkip}); err != nil {
+			t.Fatalf("%s %q: run: %v", tt.format, tt.input, err)
+		}
+		if got := out.String(); got != tt.want {
+			t.Fatalf("%s %q = %q, want %q", tt.format, tt.input, got, tt.want)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := run(strings.NewReader("{\n  \"a\": 1\n"), &out, &options{inputFormat: inputFormatAuto}); err == nil {
+		t.Fatal("auto: expected error for a truncated document, got nil")
+	}
+	if err := run(strings.NewReader("{\n\"a\":1\n}\n"), &out, &options{inputFormat: inputFormatNDJSON}); err == nil {
+		t.Fatal("ndjson: expected error for a pretty-printed document, got nil")
+	}
+}
+
+func TestInputCharsetLatin1(t *testing.T) {
+	// "café" and "Zürich" encoded as ISO-8859-1.
+	input := "{\"name\":\"caf\xe9\",\"name\":\"x\",\"city\":\"Z\xfcrich\"}"
+	got, err := dedupLine(&options{inputCharset: charsetLatin1}, input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := `{"name":"café","city":"Zürich"}`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	var out bytes.Buffer
+	if err := run(strings.NewReader(input+"\n"), &out, &options{inputCharset: charsetLatin1}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if want := "{\"name\":\"café\",\"city\":\"Zürich\"}\n"; out.String() != want {
+		t.Fatalf("run output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestRejectNonUTF8(t *testing.T) {
+	input := "{\"a\":\"caf\xe9\",\"b\":\"\xc3\xa9\"}"
+	_, err := dedupLine(&options{rejectNonUTF8: true}, input)
+	if err == nil || err.Error() != "invalid UTF-8 at byte offset 9" {
+		t.Fatalf("err = %v, want invalid UTF-8 at byte offset 9", err)
+	}
+	if got, err := dedupLine(&options{}, input); err != nil || got != input {
+		t.Fatalf("lenient = %q, %v; want bytes passed through", got, err)
+	}
+	if _, err := dedupLine(&options{rejectNonUTF8: true, inputCharset: charsetLatin1}, input); err != nil {
+		t.Fatalf("latin1 input: unexpected error: %v", err)
+	}
+	if got, err := dedupLine(&options{rejectNonUTF8: true}, `{"a":"é","a":"x"}`); err != nil || got != `{"a":"é"}` {
+		t.Fatalf("valid input = %q, %v", got, err)
+	}
+}
+
+func TestRunLinePrefixRegex(t *testing.T) {
+	opts := &options{linePrefixRegex: `^\d{4}-\d{2}-\d{2} [A-Z]+`}
+	if err := opts.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	input := "2024-01-01 INFO {\"a\":1,\"a\":2,\"url\":\"/x\"}\n" +
+		"2024-01-02 WARN   {\"b\":null,\"b\":3}\n" +
+		"{\"c\":1,\"c\":2}\n"
+	var out bytes.Buffer
+	if err := run(strings.NewReader(input), &out, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	want := "2024-01-01 INFO {\"a\":1,\"url\":\"/x\"}\n" +
+		"2024-01-02 WARN   {\"b\":3}\n" +
+		"{\"c\":1}\n"
+	if got := out.String(); got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+
+	if err := run(strings.NewReader("2024-01-01 INFO not json\n"), &out, opts); err == nil {
+		t.Fatal("expected error for a non-JSON payload, got nil")
+	}
+	if err := (&options{linePrefixRegex: "("}).load(); err == nil {
+		t.Fatal("expected error for an invalid regex, got nil")
+	}
+}
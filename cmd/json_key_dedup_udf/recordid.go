@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+const recordIDKey = "_id"
+
+// recordIDSeparator joins the key-field segments before hashing. It cannot
+// occur unescaped in serialized JSON, so segment boundaries are unambiguous.
+const recordIDSeparator = 0x1f
+
+// recordID hashes the canonical JSON of each path in n. Missing paths
+// contribute an empty segment.
+func recordID(n node, paths []string, scratch *bytes.Buffer) string {
+	scratch.Reset()
+	for i, path := range paths {
+		if i > 0 {
+			scratch.WriteByte(recordIDSeparator)
+		}
+		if value := lookupPath(n, path); value != nil {
+			value.Write(scratch)
+		}
+	}
+	sum := sha256.Sum256(scratch.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+// setRecordID stores id as the leading _id field of obj, replacing any
+// existing _id entry.
+func setRecordID(obj *objectNode, id string) {
+	vn := valueNodePool.Get().(*valueNode)
+	vn.kind = kindString
+	vn.str = id
+	vn.num = ""
+	for i := range obj.entries {
+		if obj.entries[i].key == recordIDKey {
+			obj.entries[i].value = vn
+			return
+		}
+	}
+	obj.entries = append(obj.entries, objectEntry{})
+	copy(obj.entries[1:], obj.entries)
+	obj.entries[0] = objectEntry{key: recordIDKey, value: vn}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRecordIDFromKeyFields(t *testing.T) {
+	opts := &options{idFrom: stringList{"user.id", "kind", "missing"}}
+	idOf := func(input string) string {
+		t.Helper()
+		got, err := dedupLine(opts, input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", input, err)
+		}
+		if !strings.HasPrefix(got, `{"_id":"`) {
+			t.Fatalf("%s: output %s does not start with _id", input, got)
+		}
+		return got[len(`{"_id":"`) : len(`{"_id":"`)+64]
+	}
+
+	first := idOf(`{"kind":"click","user":{"id":7},"ts":1}`)
+	second := idOf(`{"ts":2,"user.id":7,"kind":"","kind":"click"}`)
+	if first != second {
+		t.Fatalf("records with equal key fields got different ids: %s vs %s", first, second)
+	}
+	if other := idOf(`{"kind":"click","user":{"id":"7"}}`); other == first {
+		t.Fatal("string \"7\" and number 7 produced the same id")
+	}
+	if other := idOf(`{"kind":"view","user":{"id":7}}`); other == first {
+		t.Fatal("records with different key fields produced the same id")
+	}
+}
+
+func TestRecordIDReplacesExistingField(t *testing.T) {
+	got, err := dedupLine(&options{idFrom: stringList{"a"}}, `{"a":1,"_id":"old"}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(got, "old") || strings.Count(got, `"_id"`) != 1 {
+		t.Fatalf("expected a single replaced _id field, got %s", got)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// loadJSONSchema compiles the schema file at path. Schemas without a
+// $schema keyword are read as draft-07.
+func loadJSONSchema(path string) (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft7
+	return compiler.Compile(path)
+}
+
+// validateRecord checks a serialized record against schema. The error names
+// the instance path of the first failing value.
+func validateRecord(schema *jsonschema.Schema, record []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(record))
+	dec.UseNumber()
+	var instance interface{}
+	if err := dec.Decode(&instance); err != nil {
+		return fmt.Errorf("schema validation decode error: %w", err)
+	}
+
+	err := schema.Validate(instance)
+	if err == nil {
+		return nil
+	}
+	var verr *jsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		return err
+	}
+	for len(verr.Causes) > 0 {
+		verr = verr.Causes[0]
+	}
+	return fmt.Errorf("schema validation failed at #%s: %s", verr.InstanceLocation, verr.Message)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJSONSchemaValidation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schema.json")
+	schemaJSON := `{
+		"type": "object",
+		"required": ["id"],
+		"properties": {
+			"id": {"type": "integer"},
+			"user": {"type": "object", "properties": {"age": {"type": "integer", "minimum": 0}}}
+		}
+	}`
+	if err := os.WriteFile(path, []byte(schemaJSON), 0o644); err != nil {
+		t.Fatalf("write schema: %v", err)
+	}
+	schema, err := loadJSONSchema(path)
+	if err != nil {
+		t.Fatalf("loadJSONSchema: %v", err)
+	}
+	opts := &options{schema: schema}
+
+	got, err := dedupLine(opts, `{"id":1,"id":"x","user":{"age":30}}`)
+	if err != nil {
+		t.Fatalf("valid record: unexpected error: %v", err)
+	}
+	if want := `{"id":1,"user":{"age":30}}`; got != want {
+		t.Fatalf("valid record = %s, want %s", got, want)
+	}
+
+	_, err = dedupLine(opts, `{"id":2,"user":{"age":-1}}`)
+	if err == nil {
+		t.Fatal("invalid record: expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), "#/user/age") {
+		t.Fatalf("error %q does not name the failing path #/user/age", err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+const (
+	sinkDialTimeout = 5 * time.Second
+	sinkMaxAttempts = 5
+	sinkRetryDelay  = 200 * time.Millisecond
+)
+
+// netSink writes output to a TCP or Unix socket. A failed write drops the
+// connection and redials. The new peer first receives the part of the current
+// record the old one already got, so every record it sees starts at a record
+// boundary, and then the unwritten remainder.
+type netSink struct {
+	network string
+	address string
+	conn    net.Conn
+	delim   byte
+	// partial holds the bytes written to conn since the last delimiter.
+	partial []byte
+}
+
+func newNetSink(rawURL string, delim byte) (*netSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output url %q: %w", rawURL, err)
+	}
+
+	s := &netSink{network: u.Scheme, delim: delim}
+	switch u.Scheme {
+	case "tcp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid output url %q: missing host:port", rawURL)
+		}
+		s.address = u.Host
+	case "unix":
+		if u.Path == "" {
+			return nil, fmt.Errorf("invalid output url %q: missing socket path", rawURL)
+		}
+		s.address = u.Path
+	default:
+		return nil, fmt.Errorf("invalid output url %q: scheme must be tcp or unix", rawURL)
+	}
+
+	if err := s.dial(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *netSink) dial() error {
+	conn, err := net.DialTimeout(s.network, s.address, sinkDialTimeout)
+	if err != nil {
+		return fmt.Errorf("output connect error: %w", err)
+	}
+	s.conn = conn
+	return nil
+}
+
+func (s *netSink) Write(p []byte) (int, error) {
+	written := 0
+	var lastErr error
+	for attempt := 0; attempt < sinkMaxAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(sinkRetryDelay * time.Duration(attempt))
+		}
+		if s.conn == nil {
+			if err := s.dial(); err != nil {
+				lastErr = err
+				continue
+			}
+			if _, err := s.conn.Write(s.partial); err != nil {
+				lastErr = err
+				s.drop()
+				continue
+			}
+		}
+		n, err := s.conn.Write(p[written:])
+		s.track(p[written : written+n])
+		written += n
+		if err == nil {
+			return written, nil
+		}
+		lastErr = err
+		s.drop()
+	}
+	return written, fmt.Errorf("output write error after %d attempts: %w", sinkMaxAttempts, lastErr)
+}
+
+// track records sent bytes so a reconnect can resend the current record.
+func (s *netSink) track(sent []byte) {
+	if i := bytes.LastIndexByte(sent, s.delim); i >= 0 {
+		s.partial = append(s.partial[:0], sent[i+1:]...)
+		return
+	}
+	s.partial = append(s.partial, sent...)
+}
+
+func (s *netSink) drop() {
+	_ = s.conn.Close()
+	s.conn = nil
+}
+
+func (s *netSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
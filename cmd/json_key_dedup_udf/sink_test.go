@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestNetSinkWritesRecordsToTCPListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			received <- ""
+			return
+		}
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		received <- string(data)
+	}()
+
+	sink, err := newNetSink("tcp://"+ln.Addr().String(), '\n')
+	if err != nil {
+		t.Fatalf("newNetSink: %v", err)
+	}
+	writer := bufio.NewWriter(sink)
+	_, _ = writer.WriteString("{\"a\":1}\n{\"b\":2}\n")
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if got, want := <-received, "{\"a\":1}\n{\"b\":2}\n"; got != want {
+		t.Fatalf("received %q, want %q", got, want)
+	}
+}
+
+func TestNewNetSinkRejectsUnknownScheme(t *testing.T) {
+	if _, err := newNetSink("udp://127.0.0.1:9000", '\n'); err == nil {
+		t.Fatal("expected error for udp scheme, got nil")
+	}
+}
+
+// brokenConn accepts limit bytes in total and then fails every write.
+type brokenConn struct {
+	net.Conn
+	limit int
+}
+
+func (c *brokenConn) Write(p []byte) (int, error) {
+	if len(p) <= c.limit {
+		c.limit -= len(p)
+		return len(p), nil
+	}
+	n := c.limit
+	c.limit = 0
+	return n, errors.New("connection reset")
+}
+
+func (c *brokenConn) Close() error { return nil }
+
+func TestNetSinkResendsRecordAfterPartialWrite(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			received <- ""
+			return
+		}
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		received <- string(data)
+	}()
+
+	// The old connection takes the first record and part of the second,
+	// split across two writes, before it breaks.
+	sink := &netSink{network: "tcp", address: ln.Addr().String(), delim: '\n', conn: &brokenConn{limit: 12}}
+	for _, chunk := range []string{"{\"a\":1}\n{\"b\"", ":2}\n{\"c\":3}\n"} {
+		if n, err := sink.Write([]byte(chunk)); err != nil || n != len(chunk) {
+			t.Fatalf("write %q = %d, %v", chunk, n, err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if got, want := <-received, "{\"b\":2}\n{\"c\":3}\n"; got != want {
+		t.Fatalf("new connection received %q, want %q", got, want)
+	}
+}
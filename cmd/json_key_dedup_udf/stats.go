@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// dupsRemovedKey is the field -annotate-dups adds to each record.
+const dupsRemovedKey = "_dups_removed"
+
+// runStats tallies duplicate-key statistics across every processed record.
+type runStats struct {
+	Records               int `json:"records"`
+	RecordsWithDuplicates int `json:"records_with_duplicates"`
+	DuplicatesRemoved     int `json:"duplicates_removed"`
+}
+
+func (s *runStats) add(removed int) {
+	s.Records++
+	if removed > 0 {
+		s.RecordsWithDuplicates++
+		s.DuplicatesRemoved += removed
+	}
+}
+
+func (s *runStats) writeJSON(w io.Writer) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = w.Write(data)
+	return err
+}
+
+// runReport is the -dedup-report summary: the run statistics plus counters
+// that only matter to operators watching a job.
+type runReport struct {
+	runStats
+	RecordsDropped int `json:"records_dropped"`
+	Errors         int `json:"errors"`
+}
+
+// writeReport writes r as one JSON line to path, or to stderr when path is
+// "-".
+func (r *runReport) writeReport(path string) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	return writeReportFile(path, data)
+}
+
+// writeReportFile writes data to path, or to stderr when path is "-".
+func writeReportFile(path string, data []byte) error {
+	if path == "-" {
+		_, err := os.Stderr.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// keyCollisions is one line of the -collision-audit report.
+type keyCollisions struct {
+	Key        string `json:"key"`
+	Collisions int    `json:"collisions"`
+	Duplicates int    `json:"duplicates"`
+}
+
+// collisionAudit tallies, by key name at any depth, how many objects held a
+// key more than once and how many extra occurrences that added up to.
+type collisionAudit map[string]*keyCollisions
+
+func (a collisionAudit) add(key string, count int) {
+	c := a[key]
+	if c == nil {
+		c = &keyCollisions{Key: key}
+		a[key] = c
+	}
+	c.Collisions++
+	c.Duplicates += count - 1
+}
+
+// write writes one JSON line per key to path ("-" for stderr), the most
+// duplicated keys first.
+func (a collisionAudit) write(path string) error {
+	keys := make([]*keyCollisions, 0, len(a))
+	for _, c := range a {
+		keys = append(keys, c)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Duplicates != keys[j].Duplicates {
+			return keys[i].Duplicates > keys[j].Duplicates
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	var data []byte
+	for _, c := range keys {
+		line, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	return writeReportFile(path, data)
+}
+
+// setDupsRemoved appends the per-record count of dropped duplicates to obj,
+// replacing an existing _dups_removed value.
+func setDupsRemoved(obj *objectNode, removed int) {
+	vn := valueNodePool.Get().(*valueNode)
+	vn.kind = kindNumber
+	vn.num = strconv.Itoa(removed)
+	vn.str = ""
+	for i := range obj.entries {
+		if obj.entries[i].key == dupsRemovedKey {
+			obj.entries[i].value = vn
+			return
+		}
+	}
+	obj.entries = append(obj.entries, objectEntry{key: dupsRemovedKey, value: vn})
+}
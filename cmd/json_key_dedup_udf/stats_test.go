@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunCountOnlyReportsDuplicateTallies(t *testing.T) {
+	input, err := os.Open("../../testdata/input.tsv")
+	if err != nil {
+		t.Fatalf("open input: %v", err)
+	}
+	defer input.Close()
+
+	var out bytes.Buffer
+	if err := run(input, &out, &options{countOnly: true}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	want := "{\"records\":10,\"records_with_duplicates\":9,\"duplicates_removed\":12}\n"
+	if got := out.String(); got != want {
+		t.Fatalf("count-only output = %q, want %q", got, want)
+	}
+}
+
+func TestRunDedupReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	input := "{\"a\":1,\"a\":2,\"b\":3,\"b\":4}\n{}\n{\"c\":1}\n{\"d\":null,\"d\":1}\n"
+	opts := &options{dedupReport: path, dropEmptyRecords: true}
+
+	var out bytes.Buffer
+	if err := run(strings.NewReader(input), &out, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	want := "{\"records\":4,\"records_with_duplicates\":2,\"duplicates_removed\":3,\"records_dropped\":1,\"errors\":0}\n"
+	if got, err := os.ReadFile(path); err != nil || string(got) != want {
+		t.Fatalf("report = %q, %v; want %q", got, err, want)
+	}
+
+	if err := run(strings.NewReader("{\"a\":1,\"a\":2}\nnot json\n"), &out, opts); err == nil {
+		t.Fatalf("run: expected error, got nil")
+	}
+	want = "{\"records\":1,\"records_with_duplicates\":1,\"duplicates_removed\":1,\"records_dropped\":0,\"errors\":1}\n"
+	if got, err := os.ReadFile(path); err != nil || string(got) != want {
+		t.Fatalf("report after error = %q, %v; want %q", got, err, want)
+	}
+}
+
+func TestRunCollisionAudit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	input := strings.Join([]string{
+		`{"id":1,"id":2,"ts":1,"ts":2,"ts":3}`,
+		`{"ts":1,"ts":1,"n":{"ts":null,"ts":4}}`,
+		`{"id":1,"x":1}`,
+		`{"x":1,"x":2,"id":3,"id":4}`,
+	}, "\n") + "\n"
+
+	var out bytes.Buffer
+	if err := run(strings.NewReader(input), &out, &options{collisionAudit: path}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	want := "{\"key\":\"ts\",\"collisions\":3,\"duplicates\":4}\n" +
+		"{\"key\":\"id\",\"collisions\":2,\"duplicates\":2}\n" +
+		"{\"key\":\"x\",\"collisions\":1,\"duplicates\":1}\n"
+	if got, err := os.ReadFile(path); err != nil || string(got) != want {
+		t.Fatalf("audit = %q, %v; want %q", got, err, want)
+	}
+}
+
+func TestAnnotateDups(t *testing.T) {
+	opts := &options{annotateDups: true}
+	tests := map[string]string{
+		`{"a":1,"a":2,"b":{"c":null,"c":3},"d":4}`: `{"a":1,"b":{"c":3},"d":4,"_dups_removed":2}`,
+		`{"a":1}`:                     `{"a":1,"_dups_removed":0}`,
+		`{"_dups_removed":"x","a":1}`: `{"_dups_removed":0,"a":1}`,
+		`[{"a":1,"a":2}]`:             `[{"a":1}]`,
+	}
+	for input, want := range tests {
+		got, err := dedupLine(opts, input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", input, err)
+		}
+		if got != want {
+			t.Fatalf("%s = %s, want %s", input, got, want)
+		}
+	}
+}
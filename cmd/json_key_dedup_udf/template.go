@@ -0,0 +1,60 @@
+package main
+
+import "strings"
+
+const templatePathPrefix = "$"
+
+// applyTemplate builds a new object shaped like template. String values of
+// the form "$.a.b" are replaced by the value at that path in n ("$" alone
+// is the whole record); every other template value is copied as a constant.
+// Missing paths yield null, or are omitted when omitMissing is set. The
+// returned tree shares nodes with n and template and must not be recycled.
+func applyTemplate(n node, template *objectNode, omitMissing bool) *objectNode {
+	result := &objectNode{entries: make([]objectEntry, 0, len(template.entries))}
+	for _, entry := range template.entries {
+		value, ok := templateValue(n, entry.value, omitMissing)
+		if ok {
+			result.entries = append(result.entries, objectEntry{key: entry.key, value: value})
+		}
+	}
+	return result
+}
+
+func templateValue(n node, tmpl node, omitMissing bool) (node, bool) {
+	switch t := tmpl.(type) {
+	case *objectNode:
+		return applyTemplate(n, t, omitMissing), true
+	case *valueNode:
+		path, ok := templatePath(t)
+		if !ok {
+			return t, true
+		}
+		var value node = n
+		if path != "" {
+			value = lookupPath(n, path)
+		}
+		if value != nil {
+			return value, true
+		}
+		if omitMissing {
+			return nil, false
+		}
+		return &valueNode{kind: kindNull}, true
+	}
+	return tmpl, true
+}
+
+// templatePath returns the dotted path of a "$" or "$.a.b" template string.
+func templatePath(v *valueNode) (string, bool) {
+	if v.kind != kindString || !strings.HasPrefix(v.str, templatePathPrefix) {
+		return "", false
+	}
+	rest := v.str[len(templatePathPrefix):]
+	if rest == "" {
+		return "", true
+	}
+	if !strings.HasPrefix(rest, pathSeparator) || len(rest) == 1 {
+		return "", false
+	}
+	return rest[1:], true
+}
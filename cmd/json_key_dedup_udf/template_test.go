@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func loadTestTemplate(t *testing.T, content string) *objectNode {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "template.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+	template, err := loadObjectFile(path, defaultMaxSafeInt)
+	if err != nil {
+		t.Fatalf("loadObjectFile: %v", err)
+	}
+	return template
+}
+
+func TestTemplateReshapesNestedRecord(t *testing.T) {
+	template := loadTestTemplate(t, `{
+		"name": "$.user.name",
+		"total": "$.stats.count",
+		"meta": {"source": "api", "region": "$.geo.region"},
+		"raw": "$.stats"
+	}`)
+	input := `{"user":{"name":"","name":"ada"},"stats":{"count":3,"count":4}}`
+
+	got, err := dedupLine(&options{template: template}, input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `{"name":"ada","total":3,"meta":{"source":"api","region":null},"raw":{"count":3}}`
+	if got != want {
+		t.Fatalf("template output = %s, want %s", got, want)
+	}
+
+	got, err = dedupLine(&options{template: template, templateOmitMissing: true}, input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want = `{"name":"ada","total":3,"meta":{"source":"api"},"raw":{"count":3}}`
+	if got != want {
+		t.Fatalf("template output with omit-missing = %s, want %s", got, want)
+	}
+}
+
+func TestTemplateWholeRecordPath(t *testing.T) {
+	template := loadTestTemplate(t, `{"record":"$","price":"$5"}`)
+	got, err := dedupLine(&options{template: template}, `{"a":1,"a":2}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := `{"record":{"a":1},"price":"$5"}`; got != want {
+		t.Fatalf("template output = %s, want %s", got, want)
+	}
+}
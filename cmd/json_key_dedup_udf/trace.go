@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// writeTrace dumps the structure of n to w for -trace, one node per line,
+// under a heading naming the stage.
+func writeTrace(w io.Writer, stage string, n node) {
+	var buf bytes.Buffer
+	buf.WriteString("trace ")
+	buf.WriteString(stage)
+	buf.WriteString(":\n")
+	traceNode(&buf, n, 1)
+	_, _ = w.Write(buf.Bytes())
+}
+
+func traceNode(buf *bytes.Buffer, n node, depth int) {
+	switch v := n.(type) {
+	case *objectNode:
+		buf.WriteString("object (keys=")
+		buf.WriteString(strconv.Itoa(len(v.entries)))
+		if v.expanded {
+			buf.WriteString(", expanded")
+		}
+		buf.WriteString(")\n")
+		for _, entry := range v.entries {
+			buf.WriteString(strings.Repeat("  ", depth))
+			writeJSONString(buf, entry.key)
+			buf.WriteString(": ")
+			traceNode(buf, entry.value, depth+1)
+		}
+	case *arrayNode:
+		buf.WriteString("array (items=")
+		buf.WriteString(strconv.Itoa(len(v.values)))
+		buf.WriteString(")\n")
+		for i, item := range v.values {
+			buf.WriteString(strings.Repeat("  ", depth))
+			buf.WriteByte('[')
+			buf.WriteString(strconv.Itoa(i))
+			buf.WriteString("]: ")
+			traceNode(buf, item, depth+1)
+		}
+	case *valueNode:
+		switch v.kind {
+		case kindString:
+			buf.WriteString("string ")
+		case kindNumber:
+			buf.WriteString("number ")
+		case kindBool:
+			buf.WriteString("bool ")
+		}
+		v.Write(buf)
+		buf.WriteByte('\n')
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTraceDumpsTreeBeforeAndAfterDedup(t *testing.T) {
+	var trace, buf bytes.Buffer
+	ctx := &dedupContext{opts: &options{}, trace: &trace}
+	if err := processLine([]byte(`{"a.b":1,"o":{"c":null,"c":"x"},"l":[true,null]}`), &buf, ctx); err != nil {
+		t.Fatalf("processLine: %v", err)
+	}
+	want := `trace before dedup:
+object (keys=3)
+  "a.b": number 1
+  "o": object (keys=2)
+    "c": null
+    "c": string "x"
+  "l": array (items=2)
+    [0]: bool true
+    [1]: null
+trace after dedup:
+object (keys=3)
+  "a": object (keys=1, expanded)
+    "b": number 1
+  "o": object (keys=1)
+    "c": string "x"
+  "l": array (items=2)
+    [0]: bool true
+    [1]: null
+`
+	if got := trace.String(); got != want {
+		t.Fatalf("trace =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/valyala/fastjson"
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizeEntryValues applies the key-targeted value normalizations to the
+// entries of o, then -max-string-len. It runs after the children are
+// deduplicated and before duplicate selection, so the emptiness checks see
+// normalized values.
+func (o *objectNode) normalizeEntryValues(ctx *dedupContext) error {
+	for i := range o.entries {
+		vn, ok := o.entries[i].value.(*valueNode)
+		if !ok || vn.kind != kindString {
+			continue
+		}
+		if matchesKey(ctx.opts.decodeEmbedded, o.entries[i].key) {
+			if err := dedupEmbedded(vn, ctx); err != nil {
+				return fmt.Errorf("embedded json under key %q: %w", o.entries[i].key, err)
+			}
+		}
+		if matchesKey(ctx.opts.normalizeTimestamps, o.entries[i].key) {
+			normalizeTimestamp(vn)
+		}
+		if matchesKey(ctx.opts.normalizeBools, o.entries[i].key) {
+			normalizeBool(vn)
+		}
+		truncateValue(vn, ctx.opts)
+	}
+	return nil
+}
+
+// dedupEmbedded deduplicates a JSON object or array encoded inside a string
+// value and stores it back re-encoded. Strings that do not hold an object or
+// array are left alone.
+func dedupEmbedded(vn *valueNode, ctx *dedupContext) error {
+	parser := parserPool.Get().(*fastjson.Parser)
+	defer parserPool.Put(parser)
+
+	value, err := parser.Parse(vn.str)
+	if err != nil {
+		return nil
+	}
+	if t := value.Type(); t != fastjson.TypeObject && t != fastjson.TypeArray {
+		return nil
+	}
+	parsed, err := convertFastJSON(value, ctx.opts.maxNumberDigits, ctx.opts.safeIntDigits())
+	if err != nil {
+		return err
+	}
+
+	depth := ctx.depth
+	ctx.depth = 0
+	result, err := parsed.Dedup(ctx)
+	ctx.depth = depth
+	if err != nil {
+		recycleNode(parsed)
+		return err
+	}
+
+	ctx.scratch.Reset()
+	result.Write(&ctx.scratch)
+	vn.str = ctx.scratch.String()
+	recycleNode(result)
+	return nil
+}
+
+// normalizeString applies the value normalizations that target every string
+// value regardless of its key.
+func normalizeString(s string, opts *options) string {
+	if opts.replaceInvalid {
+		s = strings.ToValidUTF8(s, opts.invalidUTF8Repl)
+	}
+	if opts.stripControl {
+		s = stripControlChars(s, opts.keepControlSpace)
+	}
+	switch opts.unicodeForm {
+	case unicodeNFC:
+		s = norm.NFC.String(s)
+	case unicodeNFKC:
+		s = norm.NFKC.String(s)
+	}
+	if opts.unescapeHTML && strings.IndexByte(s, '&') >= 0 {
+		s = html.UnescapeString(s)
+	}
+	if opts.collapseSpace {
+		s = collapseWhitespace(s, opts.collapseSpaceTrim)
+	}
+	return s
+}
+
+// truncateValue applies -max-string-len to a string value.
+// It runs last, after -decode-embedded has re-encoded the value, and skips
+// integers stringified by -max-safe-int so numbers are never cut.
+func truncateValue(vn *valueNode, opts *options) {
+	if opts.maxStringLen > 0 && vn.kind == kindString && vn.num == "" {
+		vn.str = truncateString(vn.str, opts.maxStringLen, opts.maxStringRunes, opts.maxStringMarker)
+	}
+}
+
+// truncateString shortens s to at most max bytes (or runes), never splitting
+// a UTF-8 sequence. When s is cut and marker fits within max, the result ends
+// with marker.
+func truncateString(s string, max int, runes bool, marker string) string {
+	if len(s) <= max || (runes && utf8.RuneCountInString(s) <= max) {
+		return s
+	}
+	markerLen := len(marker)
+	if runes {
+		markerLen = utf8.RuneCountInString(marker)
+	}
+	if markerLen >= max {
+		marker, markerLen = "", 0
+	}
+	limit := max - markerLen
+	cut := 0
+	if runes {
+		for i := range s {
+			if limit == 0 {
+				cut = i
+				break
+			}
+			limit--
+		}
+	} else {
+		cut = limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+	}
+	return s[:cut] + marker
+}
+
+// collapseWhitespace replaces every run of whitespace in s with a single
+// space. With trim, leading and trailing runs are dropped instead.
+func collapseWhitespace(s string, trim bool) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	space := false
+	for _, r := range s {
+		if unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+		if space && (!trim || b.Len() > 0) {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteRune(r)
+	}
+	if space && !trim {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+func stripControlChars(s string, keepWhitespace bool) string {
+	strip := func(c byte) bool {
+		return c < 0x20 && !(keepWhitespace && (c == '\t' || c == '\n' || c == '\r'))
+	}
+	i := 0
+	for i < len(s) && !strip(s[i]) {
+		i++
+	}
+	if i == len(s) {
+		return s
+	}
+	b := make([]byte, i, len(s))
+	copy(b, s[:i])
+	for ; i < len(s); i++ {
+		if !strip(s[i]) {
+			b = append(b, s[i])
+		}
+	}
+	return string(b)
+}
+
+// matchesKey reports whether key is listed in keys, where "*" matches any key.
+func matchesKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key || k == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeBool turns "true"/"false" (any case) and "1"/"0" strings into
+// booleans. Other strings are left alone.
+func normalizeBool(vn *valueNode) {
+	switch {
+	case vn.str == "1" || strings.EqualFold(vn.str, "true"):
+		vn.b = true
+	case vn.str == "0" || strings.EqualFold(vn.str, "false"):
+		vn.b = false
+	default:
+		return
+	}
+	vn.kind = kindBool
+	vn.str = ""
+}
+
+// timestampLayouts are tried in order by normalizeTimestamp. Layouts without a
+// zone are read as UTC.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999 -0700",
+	"2006-01-02 15:04:05.999999999",
+	time.RFC1123Z,
+	"2006-01-02",
+}
+
+// zoneNameLayouts carry a zone abbreviation. time.Parse gives an unknown
+// abbreviation a zero offset, or the host's offset when it names the local
+// zone, so only UTC and GMT are trusted.
+var zoneNameLayouts = []string{
+	time.RFC1123,
+	time.RFC850,
+}
+
+// normalizeTimestamp rewrites a string in one of timestampLayouts as an
+// RFC 3339 UTC timestamp. Unparseable strings, and strings whose zone
+// abbreviation is not UTC or GMT, are left unchanged.
+func normalizeTimestamp(vn *valueNode) {
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, vn.str); err == nil {
+			vn.str = t.UTC().Format(time.RFC3339Nano)
+			return
+		}
+	}
+	for _, layout := range zoneNameLayouts {
+		t, err := time.Parse(layout, vn.str)
+		if err != nil {
+			continue
+		}
+		if name, offset := t.Zone(); offset == 0 && (name == "UTC" || name == "GMT") {
+			vn.str = t.UTC().Format(time.RFC3339Nano)
+		}
+		return
+	}
+}
+
+// invalidUTF8Offset returns the byte offset of the first invalid UTF-8
+// sequence in b, or -1 when b is valid.
+func invalidUTF8Offset(b []byte) int {
+	if utf8.Valid(b) {
+		return -1
+	}
+	for i := 0; i < len(b); {
+		r, size := utf8.DecodeRune(b[i:])
+		if r == utf8.RuneError && size == 1 {
+			return i
+		}
+		i += size
+	}
+	return -1
+}
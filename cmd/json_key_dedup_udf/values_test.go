@@ -0,0 +1,251 @@
+package main
+
+import "testing"
+
+func TestNormalizeBools(t *testing.T) {
+	opts := &options{normalizeBools: stringList{"active"}}
+	tests := map[string]string{
+		`{"active":"true"}`:                   `{"active":true}`,
+		`{"active":"FALSE"}`:                  `{"active":false}`,
+		`{"active":"1"}`:                      `{"active":true}`,
+		`{"active":"0"}`:                      `{"active":false}`,
+		`{"active":"yes"}`:                    `{"active":"yes"}`,
+		`{"active":"","active":"0"}`:          `{"active":false}`,
+		`{"other":"true","x":{"active":"1"}}`: `{"other":"true","x":{"active":true}}`,
+	}
+	for input, want := range tests {
+		got, err := dedupLine(opts, input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", input, err)
+		}
+		if got != want {
+			t.Fatalf("%s = %s, want %s", input, got, want)
+		}
+	}
+
+	got, err := dedupLine(&options{normalizeBools: stringList{"*"}}, `{"a":"true","b":"0","c":"n/a"}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := `{"a":true,"b":false,"c":"n/a"}`; got != want {
+		t.Fatalf("global normalize-bools = %s, want %s", got, want)
+	}
+}
+
+func TestNormalizeTimestamps(t *testing.T) {
+	opts := &options{normalizeTimestamps: stringList{"ts"}}
+	tests := map[string]string{
+		`{"ts":"2024-03-01T12:30:00+02:00"}`:       `{"ts":"2024-03-01T10:30:00Z"}`,
+		`{"ts":"2024-03-01 12:30:00.250"}`:         `{"ts":"2024-03-01T12:30:00.25Z"}`,
+		`{"ts":"Fri, 01 Mar 2024 12:30:00 -0500"}`: `{"ts":"2024-03-01T17:30:00Z"}`,
+		`{"ts":"Fri, 01 Mar 2024 12:30:00 GMT"}`:   `{"ts":"2024-03-01T12:30:00Z"}`,
+		`{"ts":"Friday, 01-Mar-24 12:30:00 UTC"}`:  `{"ts":"2024-03-01T12:30:00Z"}`,
+		`{"ts":"Mon, 02 Jan 2006 15:04:05 EST"}`:   `{"ts":"Mon, 02 Jan 2006 15:04:05 EST"}`,
+		`{"ts":"Mon, 02 Jan 2006 15:04:05 XYZ"}`:   `{"ts":"Mon, 02 Jan 2006 15:04:05 XYZ"}`,
+		`{"ts":"Mon Jan  2 15:04:05 2006"}`:        `{"ts":"Mon Jan  2 15:04:05 2006"}`,
+		`{"ts":"2024-03-01"}`:                      `{"ts":"2024-03-01T00:00:00Z"}`,
+		`{"ts":"yesterday"}`:                       `{"ts":"yesterday"}`,
+		`{"other":"2024-03-01"}`:                   `{"other":"2024-03-01"}`,
+	}
+	for input, want := range tests {
+		got, err := dedupLine(opts, input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", input, err)
+		}
+		if got != want {
+			t.Fatalf("%s = %s, want %s", input, got, want)
+		}
+	}
+}
+
+func TestNormalizeEmptyArray(t *testing.T) {
+	tests := []struct {
+		direction string
+		input     string
+		want      string
+	}{
+		{emptyArrayToNull, `{"a":[],"b":[1],"c":[[],{"d":[]}]}`, `{"a":null,"b":[1],"c":[null,{"d":null}]}`},
+		{emptyArrayToNull, `{"a":[],"a":"x"}`, `{"a":"x"}`},
+		{emptyArrayToNull, `{"a":[],"a":null}`, `{"a":null}`},
+		{emptyArrayFromNull, `{"a":null,"b":[null,1],"c":[2]}`, `{"a":[],"b":[[],1],"c":[2]}`},
+		{emptyArrayFromNull, `{"a":null,"a":"x"}`, `{"a":"x"}`},
+		{emptyArrayFromNull, `{"a":[],"a":0,"b":null,"b":[]}`, `{"a":0,"b":[]}`},
+		{"", `{"a":[],"b":null}`, `{"a":[],"b":null}`},
+	}
+	for _, tt := range tests {
+		got, err := dedupLine(&options{emptyArray: tt.direction}, tt.input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.input, err)
+		}
+		if got != tt.want {
+			t.Fatalf("direction %q %s = %s, want %s", tt.direction, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestStripControl(t *testing.T) {
+	tests := []struct {
+		keepWhitespace bool
+		input          string
+		want           string
+	}{
+		{false, `{"a":"x\u0001y","b":"t\tab\nnl"}`, `{"a":"xy","b":"tabnl"}`},
+		{true, `{"a":"x\u0001y","b":"t\tab\nnl"}`, `{"a":"xy","b":"t\tab\nnl"}`},
+		{false, `{"a":"\u0002","a":"v"}`, `{"a":"v"}`},
+		{false, `["\u001fz",{"k":"\u0000"}]`, `["z",{"k":""}]`},
+	}
+	for _, tt := range tests {
+		opts := &options{stripControl: true, keepControlSpace: tt.keepWhitespace}
+		got, err := dedupLine(opts, tt.input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.input, err)
+		}
+		if got != tt.want {
+			t.Fatalf("keepWhitespace=%v %s = %s, want %s", tt.keepWhitespace, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestCollapseWhitespace(t *testing.T) {
+	tests := []struct {
+		opts  options
+		input string
+		want  string
+	}{
+		{options{collapseSpace: true}, `{"a":"a   b\tc"}`, `{"a":"a b c"}`},
+		{options{collapseSpace: true}, `{"a":"  a \n b  "}`, `{"a":" a b "}`},
+		{options{collapseSpace: true, collapseSpaceTrim: true}, `{"a":"  a \n b  "}`, `{"a":"a b"}`},
+		{options{collapseSpace: true, collapseSpaceTrim: true}, `{"a":"   ","a":"v"}`, `{"a":"v"}`},
+		{options{collapseSpace: true}, `{"a  b":"x  y"}`, `{"a  b":"x y"}`},
+		{options{collapseSpaceKeys: true}, `{"a  b":"x  y","a b":1}`, `{"a b":"x  y"}`},
+		{options{collapseSpaceKeys: true, collapseSpaceTrim: true}, `{" k ":1}`, `{"k":1}`},
+	}
+	for _, tt := range tests {
+		got, err := dedupLine(&tt.opts, tt.input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.input, err)
+		}
+		if got != tt.want {
+			t.Fatalf("%+v %s = %s, want %s", tt.opts, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestUnescapeHTML(t *testing.T) {
+	opts := &options{unescapeHTML: true}
+	tests := map[string]string{
+		`{"a":"Tom &amp; Jerry &lt;3 &quot;x&quot; &#39;y&#39; &eacute;"}`: `{"a":"Tom & Jerry <3 \"x\" 'y' é"}`,
+		`{"a":"AT&T & co","b":"&bogus; &"}`:                                `{"a":"AT&T & co","b":"&bogus; &"}`,
+		`{"a&amp;b":"&gt;","a&amp;b":"v"}`:                                 `{"a&amp;b":">"}`,
+	}
+	for input, want := range tests {
+		got, err := dedupLine(opts, input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", input, err)
+		}
+		if got != want {
+			t.Fatalf("%s = %s, want %s", input, got, want)
+		}
+	}
+	if got, err := dedupLine(&options{}, `{"a":"&amp;"}`); err != nil || got != `{"a":"&amp;"}` {
+		t.Fatalf("without flag = %q, %v; want entity kept", got, err)
+	}
+}
+
+func TestNormalizeUnicodeValues(t *testing.T) {
+	tests := []struct {
+		form  string
+		input string
+		want  string
+	}{
+		{unicodeNFKC, `{"a":"\uff11\uff12\uff13","b":"\ufb01le"}`, `{"a":"123","b":"file"}`},
+		{unicodeNFC, `{"a":"\uff11\uff12\uff13","b":"e\u0301"}`, "{\"a\":\"\uff11\uff12\uff13\",\"b\":\"\u00e9\"}"},
+		{unicodeNFKC, `{"\uff41":"x"}`, "{\"\uff41\":\"x\"}"},
+		{"", `{"b":"e\u0301"}`, "{\"b\":\"e\u0301\"}"},
+	}
+	for _, tt := range tests {
+		got, err := dedupLine(&options{unicodeForm: tt.form}, tt.input)
+		if err != nil {
+			t.Fatalf("%s %s: unexpected error: %v", tt.form, tt.input, err)
+		}
+		if got != tt.want {
+			t.Fatalf("%s %s = %s, want %s", tt.form, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestReplaceInvalidUTF8(t *testing.T) {
+	tests := []struct {
+		repl  string
+		input string
+		want  string
+	}{
+		{"\uFFFD", "{\"a\":\"caf\xe9\",\"b\":\"\xff\xfex\xc3\xa9\"}", "{\"a\":\"caf\uFFFD\",\"b\":\"\uFFFDx\u00e9\"}"},
+		{"?", "{\"a\":\"caf\xe9\",\"k\xff\":1}", "{\"a\":\"caf?\",\"k\xff\":1}"},
+		{"", "{\"a\":\"\xe9\",\"a\":\"v\"}", "{\"a\":\"v\"}"},
+	}
+	for _, tt := range tests {
+		got, err := dedupLine(&options{replaceInvalid: true, invalidUTF8Repl: tt.repl}, tt.input)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tt.input, err)
+		}
+		if got != tt.want {
+			t.Fatalf("repl %q: %q = %q, want %q", tt.repl, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestMaxStringLen(t *testing.T) {
+	tests := []struct {
+		opts  options
+		input string
+		want  string
+	}{
+		{options{maxStringLen: 5}, `{"a":"abcdefgh","b":"abc"}`, `{"a":"abcde","b":"abc"}`},
+		{options{maxStringLen: 5, maxStringMarker: "..."}, `{"a":"abcdefgh","b":"abcde"}`, `{"a":"ab...","b":"abcde"}`},
+		{options{maxStringLen: 2, maxStringMarker: "..."}, `{"a":"abcdefgh"}`, `{"a":"ab"}`},
+		{options{maxStringLen: 4}, `{"a":"héllo"}`, "{\"a\":\"h\u00e9l\"}"},
+		{options{maxStringLen: 2}, `{"a":"héllo"}`, `{"a":"h"}`},
+		{options{maxStringLen: 3, maxStringRunes: true}, `{"a":"éééé"}`, "{\"a\":\"\u00e9\u00e9\u00e9\"}"},
+		{options{maxStringLen: 3, maxStringRunes: true, maxStringMarker: "…"}, `{"a":"éééé"}`, "{\"a\":\"\u00e9\u00e9\u2026\"}"},
+		{options{maxStringLen: 3}, `{"abcdef":1}`, `{"abcdef":1}`},
+		{options{maxStringLen: 3, maxStringKeys: true}, `{"abcdef":1,"abcxyz":2}`, `{"abc":1}`},
+		{options{maxStringLen: 3}, `{"a":["abcdef",1],"b":[["xyzw"]]}`, `{"a":["abc",1],"b":[["xyz"]]}`},
+		{options{maxStringLen: 3}, `"abcdef"`, `"abc"`},
+		{options{maxStringLen: 3}, `12345678901234567890`, `"12345678901234567890"`},
+		{options{maxStringLen: 5}, `{"n":12345678901234567890,"m":[-98765432109876543210],"s":"abcdefgh"}`, `{"n":"12345678901234567890","m":["-98765432109876543210"],"s":"abcde"}`},
+		{options{maxStringLen: 5, normalizeScientific: true}, `{"n":1e20}`, `{"n":"100000000000000000000"}`},
+		{options{maxStringLen: 20, decodeEmbedded: stringList{"p"}}, `{"p":"{\"k\":\"v\",\"k\":\"w\",\"z\":1}"}`, `{"p":"{\"k\":\"v\",\"z\":1}"}`},
+		{options{maxStringLen: 10, decodeEmbedded: stringList{"p"}}, `{"p":"{\"k\":\"v\",\"k\":\"w\",\"z\":1}"}`, `{"p":"{\"k\":\"v\",\""}`},
+	}
+	for _, tt := range tests {
+		got, err := dedupLine(&tt.opts, tt.input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.input, err)
+		}
+		if got != tt.want {
+			t.Fatalf("%+v %s = %s, want %s", tt.opts, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeEmbedded(t *testing.T) {
+	opts := &options{decodeEmbedded: stringList{"payload"}}
+	tests := map[string]string{
+		`{"payload":"{\"a\":null,\"a\":1,\"b.c\":2}"}`:          `{"payload":"{\"a\":1,\"b\":{\"c\":2}}"}`,
+		`{"payload":"[{\"k\":\"\",\"k\":\"v\"}]"}`:              `{"payload":"[{\"k\":\"v\"}]"}`,
+		`{"payload":"not json"}`:                                `{"payload":"not json"}`,
+		`{"payload":"42"}`:                                      `{"payload":"42"}`,
+		`{"other":"{\"a\":1,\"a\":2}"}`:                         `{"other":"{\"a\":1,\"a\":2}"}`,
+		`{"env":{"payload":"{\"x\":\"\",\"x\":\"y\"}"},"id":1}`: `{"env":{"payload":"{\"x\":\"y\"}"},"id":1}`,
+	}
+	for input, want := range tests {
+		got, err := dedupLine(opts, input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", input, err)
+		}
+		if got != want {
+			t.Fatalf("%s = %s, want %s", input, got, want)
+		}
+	}
+}
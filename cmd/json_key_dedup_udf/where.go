@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/valyala/fastjson"
+)
+
+// whereCond is one -where condition: the value at a dotted path must equal
+// a scalar literal.
+type whereCond struct {
+	path  string
+	value valueNode
+}
+
+// parseWhere parses a path=value condition. The value is read as a JSON
+// scalar when it is one (5, true, null, "5"), otherwise as a bare string.
+func parseWhere(s string) (whereCond, error) {
+	path, literal, ok := strings.Cut(s, "=")
+	if !ok || path == "" {
+		return whereCond{}, fmt.Errorf("invalid -where %q: want path=value", s)
+	}
+	cond := whereCond{path: path, value: valueNode{kind: kindString, str: literal}}
+	value, err := fastjson.Parse(literal)
+	if err != nil {
+		return cond, nil
+	}
+	switch value.Type() {
+	case fastjson.TypeNumber:
+		cond.value = valueNode{kind: kindNumber, num: literal}
+	case fastjson.TypeString:
+		cond.value.str = string(value.GetStringBytes())
+	case fastjson.TypeTrue, fastjson.TypeFalse:
+		cond.value = valueNode{kind: kindBool, b: value.Type() == fastjson.TypeTrue}
+	case fastjson.TypeNull:
+		cond.value = valueNode{kind: kindNull}
+	}
+	return cond, nil
+}
+
+// matchesWhere reports whether n satisfies every condition. A missing path
+// or a non-scalar value never matches; numbers compare exactly, so 5 equals
+// 5.0.
+func matchesWhere(n node, conds []whereCond) bool {
+	for _, cond := range conds {
+		vn, ok := lookupPath(n, cond.path).(*valueNode)
+		if !ok || !scalarEqual(vn, &cond.value) {
+			return false
+		}
+	}
+	return true
+}
+
+func scalarEqual(a, b *valueNode) bool {
+	if a.kind != b.kind {
+		return false
+	}
+	switch a.kind {
+	case kindString:
+		return a.str == b.str
+	case kindNumber:
+		x, errX := parseExactNumber(a.num)
+		y, errY := parseExactNumber(b.num)
+		if errX != nil || errY != nil {
+			return a.num == b.num
+		}
+		return x.cmp(y) == 0
+	case kindBool:
+		return a.b == b.b
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseWhere(t *testing.T) {
+	tests := []struct {
+		input string
+		want  whereCond
+	}{
+		{"status=active", whereCond{path: "status", value: valueNode{kind: kindString, str: "active"}}},
+		{`a.b="5"`, whereCond{path: "a.b", value: valueNode{kind: kindString, str: "5"}}},
+		{"n=5", whereCond{path: "n", value: valueNode{kind: kindNumber, num: "5"}}},
+		{"ok=true", whereCond{path: "ok", value: valueNode{kind: kindBool, b: true}}},
+		{"x=null", whereCond{path: "x", value: valueNode{kind: kindNull}}},
+		{"s=a=b", whereCond{path: "s", value: valueNode{kind: kindString, str: "a=b"}}},
+		{"s=", whereCond{path: "s", value: valueNode{kind: kindString}}},
+	}
+	for _, tt := range tests {
+		got, err := parseWhere(tt.input)
+		if err != nil {
+			t.Fatalf("parseWhere(%q): unexpected error: %v", tt.input, err)
+		}
+		if got != tt.want {
+			t.Fatalf("parseWhere(%q) = %+v, want %+v", tt.input, got, tt.want)
+		}
+	}
+	for _, input := range []string{"status", "=x"} {
+		if _, err := parseWhere(input); err == nil {
+			t.Fatalf("parseWhere(%q): expected error, got nil", input)
+		}
+	}
+}
+
+func TestRunWhere(t *testing.T) {
+	input := strings.Join([]string{
+		`{"id":1,"user":{"role":"admin"},"n":5}`,
+		`{"id":2,"user":{"role":"guest"},"n":5}`,
+		`{"id":3,"n":5.0}`,
+		`{"id":4,"user":{"role":"","role":"admin"},"n":5.0}`,
+		`{"id":5,"user":"admin","n":5}`,
+	}, "\n") + "\n"
+	tests := []struct {
+		conds []string
+		want  string
+	}{
+		{[]string{"user.role=admin"}, "{\"id\":1,\"user\":{\"role\":\"admin\"},\"n\":5}\n{\"id\":4,\"user\":{\"role\":\"admin\"},\"n\":5.0}\n"},
+		{[]string{"n=5", "id=3"}, "{\"id\":3,\"n\":5.0}\n"},
+		{[]string{"n=5", "id=2", "user.role=admin"}, ""},
+		{[]string{"n=\"5\""}, ""},
+	}
+	for _, tt := range tests {
+		opts := &options{}
+		for _, c := range tt.conds {
+			cond, err := parseWhere(c)
+			if err != nil {
+				t.Fatalf("parseWhere(%q): %v", c, err)
+			}
+			opts.where = append(opts.where, cond)
+		}
+		var out bytes.Buffer
+		if err := run(strings.NewReader(input), &out, opts); err != nil {
+			t.Fatalf("%v: run: %v", tt.conds, err)
+		}
+		if got := out.String(); got != tt.want {
+			t.Fatalf("%v: output = %q, want %q", tt.conds, got, tt.want)
+		}
+	}
+}
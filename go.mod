@@ -2,4 +2,8 @@ module json_key_deduplicator_udf
 
 go 1.22
 
-require github.com/valyala/fastjson v1.6.7
+require (
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
+	github.com/valyala/fastjson v1.6.7
+	golang.org/x/text v0.21.0
+)